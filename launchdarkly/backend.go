@@ -3,9 +3,12 @@ package launchdarkly
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
 
 	"github.com/pkg/errors"
@@ -25,7 +28,26 @@ type backend struct {
 	*framework.Backend
 	store map[string][]byte
 
+	// clients caches one LaunchDarkly client per connection.
+	clients     map[string]*Client
 	clientMutex sync.RWMutex
+
+	// limiters holds the rate limiter of each connection. They outlive the
+	// cached clients, so that config writes and rotations count against the
+	// same budget as every other call of the connection.
+	limiters     map[string]*rateLimiter
+	limiterMutex sync.Mutex
+
+	// configLocks serialize the changes to the stored configuration of each
+	// connection, so that a rotation does not race a config write.
+	configLocks []*locksutil.LockEntry
+
+	// storage is the storage of the mount, used to flush the revocation queue
+	// when the backend shuts down.
+	storage     logical.Storage
+	revokeQueue revokeQueue
+
+	reconcileSchedule reconcileSchedule
 }
 
 // Backend creates a new backend.
@@ -33,7 +55,16 @@ func Backend(c *logical.BackendConfig) *backend {
 	//var b backend
 
 	b := &backend{
-		store: make(map[string][]byte),
+		store:       make(map[string][]byte),
+		clients:     make(map[string]*Client),
+		limiters:    make(map[string]*rateLimiter),
+		configLocks: locksutil.CreateLocks(),
+		reconcileSchedule: reconcileSchedule{
+			last: make(map[string]time.Time),
+		},
+	}
+	if c != nil {
+		b.storage = c.StorageView
 	}
 
 	b.Backend = &framework.Backend{
@@ -42,6 +73,7 @@ func Backend(c *logical.BackendConfig) *backend {
 		PathsSpecial: &logical.Paths{
 			SealWrapStorage: []string{
 				"config",
+				"config/*",
 			},
 		},
 		Paths: []*framework.Path{
@@ -65,44 +97,294 @@ get help.
 				HelpSynopsis: "Configure LaunchDarkly secret engine.",
 				HelpDescription: `
 
-Configure launchdarkly secret engine.
+Configure launchdarkly secret engine. This is the default connection, used by
+every role, policy and path that does not name another connection.
+
+`,
+				Fields: configFields(),
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ReadOperation:   b.pathConfigRead,
+					logical.UpdateOperation: b.pathConfigWrite,
+				},
+			},
+			&framework.Path{
+				Pattern:      "config/?$",
+				HelpSynopsis: "List the configured LaunchDarkly connections.",
+				Fields:       listFields(),
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ListOperation: b.pathConfigList,
+				},
+			},
+			// launchdarkly/config/rotate-root
+			&framework.Path{
+				Pattern:      "config/rotate-root",
+				HelpSynopsis: "Rotate the configured LaunchDarkly access token.",
+				HelpDescription: `
+
+Resets the configured access token through the LaunchDarkly API and stores the
+new value. The previous value stops working immediately.
+
+`,
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.UpdateOperation: b.pathConfigRotateRoot,
+				},
+			},
+			// launchdarkly/config/<connection>/rotate-root
+			&framework.Path{
+				Pattern:      "config/" + GenericLDKeyWithAtRegex("connection") + "/rotate-root",
+				HelpSynopsis: "Rotate the access token of a LaunchDarkly connection.",
+				Fields: map[string]*framework.FieldSchema{
+					"connection": {
+						Type:        framework.TypeLowerCaseString,
+						Description: "The name of the connection.",
+					},
+				},
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.UpdateOperation: b.pathConfigRotateRoot,
+				},
+			},
+			// launchdarkly/config/<connection>
+			&framework.Path{
+				Pattern:      "config/" + GenericLDKeyWithAtRegex("connection"),
+				HelpSynopsis: "Configure a named LaunchDarkly connection.",
+				HelpDescription: `
+
+Configure a named connection to a LaunchDarkly account. Roles, relay policies,
+coderefs and project paths select it through their connection parameter. The
+connection named "default" is the one stored at config.
+
+`,
+				Fields: connectionFields(),
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ReadOperation:   b.pathConfigRead,
+					logical.UpdateOperation: b.pathConfigWrite,
+					logical.DeleteOperation: b.pathConfigDelete,
+				},
+			},
+			// launchdarkly/reconcile
+			&framework.Path{
+				Pattern:      "reconcile",
+				HelpSynopsis: "Find LaunchDarkly credentials the plugin has lost track of.",
+				HelpDescription: `
+
+Lists the access tokens and relay proxy configs of a connection and compares
+them with the credentials the plugin has issued. Credentials named with the
+owner_prefix of the connection but unknown to the plugin are reported as
+orphaned, and issued credentials that no longer exist in LaunchDarkly as
+missing. With dry_run=false orphaned credentials are deleted and the records of
+missing ones are removed.
 
 `,
 				Fields: map[string]*framework.FieldSchema{
-					"access_token": &framework.FieldSchema{
+					"connection": {
+						Type:        framework.TypeLowerCaseString,
+						Description: "The LaunchDarkly connection to reconcile. Defaults to the default connection.",
+					},
+					"dry_run": {
+						Type:        framework.TypeBool,
+						Description: "Only report what would be cleaned up.",
+						Default:     true,
+					},
+					"grace_period": {
+						Type:        framework.TypeDurationSecond,
+						Description: "Leave alone credentials created or issued more recently than this.",
+						Default:     int(defaultReconcileGracePeriod.Seconds()),
+					},
+				},
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.UpdateOperation: b.pathReconcile,
+				},
+			},
+			// launchdarkly/revoke-all
+			&framework.Path{
+				Pattern:      "revoke-all",
+				HelpSynopsis: "Delete every LaunchDarkly credential issued by this mount.",
+				HelpDescription: `
+
+Deletes the credentials recorded under issued/, and those carrying the
+owner_prefix of their connection, directly from LaunchDarkly without going
+through their leases. The filters narrow the deletion down; credentials without
+a record only match the creation time filters and secret_type=relay, since role
+and coderefs tokens cannot be told apart without one. Each deletion is logged,
+and the ones that fail are queued under revoke-queue/.
+
+`,
+				Fields: map[string]*framework.FieldSchema{
+					"connection": {
+						Type:        framework.TypeLowerCaseString,
+						Description: "Only delete the credentials of this connection.",
+					},
+					"secret_type": {
 						Type:        framework.TypeString,
-						Description: "LaunchDarkly access Token",
-						Default:     "",
+						Description: "Only delete the credentials issued through this path: role, coderefs or relay.",
+					},
+					"role": {
+						Type:        framework.TypeLowerCaseString,
+						Description: "Only delete the credentials issued for this role.",
 					},
-					"base_uri": &framework.FieldSchema{
+					"created_after": {
 						Type:        framework.TypeString,
-						Description: "LaunchDarkly baseUri.",
-						Default:     "",
+						Description: "Only delete the credentials created at or after this RFC 3339 time.",
 					},
-					"ttl": {
-						Type:        framework.TypeDurationSecond,
-						Description: "Default lease for generated keys. If <= 0, will use system default.",
+					"created_before": {
+						Type:        framework.TypeString,
+						Description: "Only delete the credentials created before this RFC 3339 time.",
 					},
-					"max_ttl": {
+				},
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.UpdateOperation: b.pathRevokeAll,
+				},
+			},
+			&framework.Path{
+				Pattern:      "issued/?$",
+				HelpSynopsis: "List the LaunchDarkly credentials issued by this mount.",
+				Fields:       listFields(),
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ListOperation: b.pathIssuedList,
+				},
+			},
+			// launchdarkly/issued/<id>/revoke
+			&framework.Path{
+				Pattern:      "issued/" + GenericLDKeyWithAtRegex("id") + "/revoke",
+				HelpSynopsis: "Delete an issued credential from LaunchDarkly.",
+				HelpDescription: `
+
+Deletes the LaunchDarkly credential by its id, without looking up its lease. The
+lease itself is left to expire.
+
+`,
+				Fields: map[string]*framework.FieldSchema{
+					"id": {
+						Type:        framework.TypeString,
+						Description: "The LaunchDarkly id of the credential.",
+					},
+				},
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.UpdateOperation: b.pathIssuedRevoke,
+				},
+			},
+			// launchdarkly/issued/<id>/rotate
+			&framework.Path{
+				Pattern:      "issued/" + GenericLDKeyWithAtRegex("id") + "/rotate",
+				HelpSynopsis: "Reset the secret value of an issued credential.",
+				HelpDescription: `
+
+Resets the token or relay proxy config key in LaunchDarkly and returns the new
+value. The credential keeps its id, custom roles or policy, and its lease. The
+old value stops working at once unless old_value_ttl is set.
+
+`,
+				Fields: map[string]*framework.FieldSchema{
+					"id": {
+						Type:        framework.TypeString,
+						Description: "The LaunchDarkly id of the credential.",
+					},
+					"old_value_ttl": {
 						Type:        framework.TypeDurationSecond,
-						Description: "Maximum time a service account key is valid for. If <= 0, will use system default.",
+						Description: "How long the old value keeps working, so consumers can switch without downtime.",
 					},
 				},
 				Callbacks: map[logical.Operation]framework.OperationFunc{
-					logical.ReadOperation:   b.pathConfigRead,
-					logical.UpdateOperation: b.pathConfigWrite,
+					logical.UpdateOperation: b.pathIssuedRotate,
 				},
 			},
+			// launchdarkly/issued/<id>
 			&framework.Path{
-				Pattern: "role",
+				Pattern:      "issued/" + GenericLDKeyWithAtRegex("id"),
+				HelpSynopsis: "Show who a LaunchDarkly credential was issued to.",
 				Fields: map[string]*framework.FieldSchema{
-					"name": {
+					"id": {
+						Type:        framework.TypeString,
+						Description: "The LaunchDarkly id of the credential.",
+					},
+				},
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ReadOperation: b.pathIssuedRead,
+				},
+			},
+			&framework.Path{
+				Pattern:      "revoke-queue/?$",
+				HelpSynopsis: "List the revocations waiting to be retried.",
+				Fields:       listFields(),
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ListOperation: b.pathRevokeQueueList,
+				},
+			},
+			// launchdarkly/revoke-queue/retry
+			&framework.Path{
+				Pattern:      "revoke-queue/retry",
+				HelpSynopsis: "Retry every queued revocation now.",
+				HelpDescription: `
+
+Revocations that LaunchDarkly could not complete are queued and retried by the
+periodic function with an increasing backoff. This retries all of them at once.
+
+`,
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.UpdateOperation: b.pathRevokeQueueRetry,
+				},
+			},
+			// launchdarkly/revoke-queue/<id>
+			&framework.Path{
+				Pattern:      "revoke-queue/" + GenericLDKeyWithAtRegex("id"),
+				HelpSynopsis: "Inspect or drop a queued revocation.",
+				Fields: map[string]*framework.FieldSchema{
+					"id": {
+						Type:        framework.TypeString,
+						Description: "The LaunchDarkly id of the credential.",
+					},
+				},
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ReadOperation:   b.pathRevokeQueueRead,
+					logical.DeleteOperation: b.pathRevokeQueueDelete,
+				},
+			},
+			&framework.Path{
+				Pattern:      "customroles/?$",
+				HelpSynopsis: "List the custom roles of a LaunchDarkly connection.",
+				Fields: func() map[string]*framework.FieldSchema {
+					fields := listFields()
+					fields["connection"] = &framework.FieldSchema{
 						Type:        framework.TypeLowerCaseString,
-						Description: "The name of the role.",
+						Description: "The LaunchDarkly connection to list the custom roles of. Defaults to the default connection.",
+					}
+					return fields
+				}(),
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ListOperation: b.pathCustomRolesList,
+				},
+			},
+			// launchdarkly/customroles/<key>
+			&framework.Path{
+				Pattern:      "customroles/" + GenericLDKeyWithAtRegex("key"),
+				HelpSynopsis: "Show a LaunchDarkly custom role and its policy.",
+				HelpDescription: `
+
+Reads a custom role from LaunchDarkly, so that the keys roles can use in
+custom_role_ids and what they grant can be found without LaunchDarkly admin
+access.
+
+`,
+				Fields: map[string]*framework.FieldSchema{
+					"key": {
+						Type:        framework.TypeString,
+						Description: "The key of the custom role.",
+					},
+					"connection": {
+						Type:        framework.TypeLowerCaseString,
+						Description: "The LaunchDarkly connection to read the custom role from. Defaults to the default connection.",
 					},
 				},
 				Callbacks: map[logical.Operation]framework.OperationFunc{
-					logical.ListOperation: b.pathRoleRead,
+					logical.ReadOperation: b.pathCustomRolesRead,
+				},
+			},
+			&framework.Path{
+				Pattern:      "role/?$",
+				HelpSynopsis: "List the configured roles.",
+				Fields:       listFields(),
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ListOperation: b.pathRoleList,
 				},
 			},
 			&framework.Path{
@@ -118,6 +400,10 @@ Configure launchdarkly secret engine.
 						Description: "The name to be used for the token.",
 						Required:    true,
 					},
+					"connection": {
+						Type:        framework.TypeLowerCaseString,
+						Description: "The LaunchDarkly connection relay tokens are created through. Defaults to the default connection.",
+					},
 				},
 				Callbacks: map[logical.Operation]framework.OperationFunc{
 					logical.CreateOperation: b.pathRelayWrite,
@@ -125,6 +411,14 @@ Configure launchdarkly secret engine.
 					logical.DeleteOperation: b.pathRelayDelete,
 				},
 			},
+			&framework.Path{
+				Pattern:      "relay/policy/?$",
+				HelpSynopsis: "List the stored relay policies.",
+				Fields:       listFields(),
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ListOperation: b.pathRelayPolicyList,
+				},
+			},
 			&framework.Path{
 				Pattern: "relay/" + GenericLDKeyWithAtRegex("name"),
 				Fields: map[string]*framework.FieldSchema{
@@ -139,20 +433,128 @@ Configure launchdarkly secret engine.
 				},
 			},
 			&framework.Path{
-				Pattern: "role/" + GenericLDKeyWithAtRegex("customrole"),
+				Pattern:      "role/" + GenericLDKeyWithAtRegex("name"),
+				HelpSynopsis: "Manage the roles that can be used to generate LaunchDarkly tokens.",
 				Fields: map[string]*framework.FieldSchema{
-					"customrole": {
+					"name": {
 						Type:        framework.TypeLowerCaseString,
-						Description: "The name of the custom role to create a token for. Must be an existing custom role.",
+						Description: "The name of the role.",
+					},
+					"custom_role_ids": {
+						Type:        framework.TypeCommaStringSlice,
+						Description: "The LaunchDarkly custom role keys the generated tokens are bound to.",
+					},
+					"inline_policy": {
+						Type:        framework.TypeString,
+						Description: "JSON list of LaunchDarkly policy statements the generated tokens are bound to, instead of custom_role_ids.",
+					},
+					"builtin_role": {
+						Type:        framework.TypeString,
+						Description: "The LaunchDarkly built-in role of the generated tokens, instead of custom_role_ids: reader, writer or admin.",
+					},
+					"service_token": {
+						Type:        framework.TypeBool,
+						Description: "Whether the generated tokens are service tokens. Otherwise they are personal tokens of the member who owns the configured access token.",
+						Default:     true,
+					},
+					"allowed_projects": {
+						Type:        framework.TypeCommaStringSlice,
+						Description: "Glob patterns of the project keys creds can fill the {{project}} placeholder of inline_policy with.",
+					},
+					"allowed_environments": {
+						Type:        framework.TypeCommaStringSlice,
+						Description: "Glob patterns of the environment keys creds can fill the {{env}} placeholder of inline_policy with.",
+					},
+					"token_name": {
+						Type:        framework.TypeString,
+						Description: "The name to be used for the generated tokens.",
+						Default:     "vault-generated",
+					},
+					"ttl": {
+						Type:        framework.TypeDurationSecond,
+						Description: "Default lease for tokens generated from this role. If <= 0, will use the config default.",
+					},
+					"max_ttl": {
+						Type:        framework.TypeDurationSecond,
+						Description: "Maximum time a token generated from this role is valid for. If <= 0, will use the config default.",
+					},
+					"default_api_version": {
+						Type:        framework.TypeInt,
+						Description: "The default LaunchDarkly API version for the generated tokens.",
+						Default:     20191212,
+					},
+					"period": {
+						Type:        framework.TypeDurationSecond,
+						Description: "Renew the leases of generated tokens by this much each time, with no max_ttl. If <= 0, leases end at max_ttl.",
 					},
+					"renewable": {
+						Type:        framework.TypeBool,
+						Description: "Whether the leases of generated tokens can be renewed.",
+						Default:     true,
+					},
+					"idle_timeout": {
+						Type:        framework.TypeDurationSecond,
+						Description: "Delete generated tokens that LaunchDarkly has not seen used for this long, even if their lease is still valid. If <= 0, tokens are kept until their lease ends.",
+					},
+					"connection": {
+						Type:        framework.TypeLowerCaseString,
+						Description: "The LaunchDarkly connection tokens are created through. Defaults to the default connection.",
+					},
+					"name_template": {
+						Type:        framework.TypeString,
+						Description: "Go template for the names of the generated tokens, overriding token_name and the template of the connection.",
+					},
+					"description_template": {
+						Type:        framework.TypeString,
+						Description: "Go template for the descriptions of the generated tokens, overriding the template of the connection.",
+					},
+				},
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.CreateOperation: b.pathRoleWrite,
+					logical.UpdateOperation: b.pathRoleWrite,
+					logical.ReadOperation:   b.pathRoleRead,
+					logical.DeleteOperation: b.pathRoleDelete,
+				},
+			},
+			&framework.Path{
+				Pattern:      "creds/" + GenericLDKeyWithAtRegex("name"),
+				HelpSynopsis: "Generate a LaunchDarkly token for a role.",
+				Fields: map[string]*framework.FieldSchema{
 					"name": {
 						Type:        framework.TypeLowerCaseString,
-						Description: "The name to be used for the token.",
-						Default:     "vault-generated",
+						Description: "The name of the role to generate a token for.",
+					},
+					"ttl": {
+						Type:        framework.TypeDurationSecond,
+						Description: "Lease for the generated token, capped by the max_ttl of the role. If <= 0, the role default is used.",
+					},
+					"project": {
+						Type:        framework.TypeString,
+						Description: "The project key for the {{project}} placeholder of the inline_policy of the role.",
+					},
+					"environment": {
+						Type:        framework.TypeString,
+						Description: "The environment key for the {{env}} placeholder of the inline_policy of the role.",
 					},
 				},
 				Callbacks: map[logical.Operation]framework.OperationFunc{
-					logical.ReadOperation: b.pathRoleRead,
+					logical.ReadOperation:   b.pathCredsRead,
+					logical.UpdateOperation: b.pathCredsRead,
+				},
+			},
+			&framework.Path{
+				Pattern:      "project/?$",
+				HelpSynopsis: "List the projects with cached environment keys.",
+				Fields: func() map[string]*framework.FieldSchema {
+					fields := listFields()
+					fields["connection"] = &framework.FieldSchema{
+						Type:        framework.TypeLowerCaseString,
+						Description: "The LaunchDarkly connection to list the cached projects of. Defaults to the default connection.",
+					}
+					return fields
+				}(),
+				Callbacks: map[logical.Operation]framework.OperationFunc{
+					logical.ListOperation: b.pathProjectList,
 				},
 			},
 			&framework.Path{
@@ -166,6 +568,10 @@ Configure launchdarkly secret engine.
 						Type:        framework.TypeLowerCaseString,
 						Description: "The env of the project.",
 					},
+					"connection": {
+						Type:        framework.TypeLowerCaseString,
+						Description: "The LaunchDarkly connection to read the project from. Defaults to the default connection.",
+					},
 				},
 				Callbacks: map[logical.Operation]framework.OperationFunc{
 					logical.ReadOperation: b.pathProjectEnvRead,
@@ -190,6 +596,10 @@ Configure launchdarkly secret engine.
 						Required:      true,
 						AllowedValues: []interface{}{"mobile", "sdk"},
 					},
+					"connection": {
+						Type:        framework.TypeLowerCaseString,
+						Description: "The LaunchDarkly connection the project belongs to. Defaults to the default connection.",
+					},
 				},
 				Callbacks: map[logical.Operation]framework.OperationFunc{
 					logical.ReadOperation: b.pathProjectReset,
@@ -203,6 +613,10 @@ Configure launchdarkly secret engine.
 						Description: "The name of the project.",
 						Required:    true,
 					},
+					"connection": {
+						Type:        framework.TypeLowerCaseString,
+						Description: "The LaunchDarkly connection tokens are created through. Defaults to the default connection.",
+					},
 				},
 				Callbacks: map[logical.Operation]framework.OperationFunc{
 					logical.ReadOperation: b.pathCoderefsRead,
@@ -212,14 +626,112 @@ Configure launchdarkly secret engine.
 		Secrets: []*framework.Secret{
 			b.programmaticAPIKeys(),
 		},
+		WALRollback:  b.walRollback,
+		PeriodicFunc: b.periodicFunc,
+		Invalidate:   b.invalidate,
+		Clean:        b.clean,
 	}
 
 	return b
 }
 
+// Close makes a last attempt at the queued revocations and drops every cached
+// client, so that they are rebuilt from the stored configuration on next use.
 func (b *backend) Close() {
+	b.flushRevokeQueue()
+
 	b.clientMutex.Lock()
 	defer b.clientMutex.Unlock()
+
+	for connection, client := range b.clients {
+		client.close()
+		delete(b.clients, connection)
+	}
+}
+
+// resetClient drops the cached client of a connection.
+func (b *backend) resetClient(connection string) {
+	b.clientMutex.Lock()
+	defer b.clientMutex.Unlock()
+
+	if client, ok := b.clients[connection]; ok {
+		client.close()
+		delete(b.clients, connection)
+	}
+}
+
+// client returns the cached client of a connection, building it from the
+// stored configuration on first use.
+func (b *backend) client(ctx context.Context, s logical.Storage, connection string) (*Client, error) {
+	b.clientMutex.RLock()
+	client, ok := b.clients[connection]
+	b.clientMutex.RUnlock()
+	if ok {
+		return client, nil
+	}
+
+	b.clientMutex.Lock()
+	defer b.clientMutex.Unlock()
+
+	if client, ok := b.clients[connection]; ok {
+		return client, nil
+	}
+
+	config, err := getConfig(b, ctx, s, connection)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err = b.newConnectionClient(connection, config)
+	if err != nil {
+		return nil, err
+	}
+	b.clients[connection] = client
+
+	return client, nil
+}
+
+// invalidate drops cached clients when their configuration is changed,
+// including by another node of the cluster.
+func (b *backend) invalidate(ctx context.Context, key string) {
+	switch {
+	case key == "config":
+		b.resetClient(defaultConnection)
+	case strings.HasPrefix(key, "config/"):
+		b.resetClient(strings.TrimPrefix(key, "config/"))
+	}
+}
+
+func (b *backend) clean(ctx context.Context) {
+	b.Close()
+}
+
+// periodicFunc runs the backend's scheduled maintenance.
+func (b *backend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	// Performance standbys and secondaries leave the maintenance to the node
+	// that can write the storage of the mount.
+	if !b.canWriteStorage() {
+		return nil
+	}
+
+	var errs []string
+	if err := b.rotateRootsIfDue(ctx, req.Storage); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if _, _, err := b.processRevokeQueue(ctx, req.Storage, false); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := b.reconcileIfDue(ctx, req.Storage); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if err := b.revokeIdleCredentials(ctx, req.Storage); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
 }
 
 func (b *backend) programmaticAPIKeys() *framework.Secret {
@@ -258,37 +770,77 @@ func (b *backend) programmaticAPIKeyRevoke(ctx context.Context, req *logical.Req
 		return nil, fmt.Errorf("secret is missing credential_type internal data")
 	}
 
-	config, err := getConfig(b, ctx, req.Storage)
-	if err != nil {
-		return nil, err
+	entry := &revokeQueueEntry{
+		ID:             programmaticAPIKeyID,
+		CredentialType: KeyType,
+		Connection:     secretConnection(req.Secret),
 	}
-	switch KeyType {
-	case "api":
-		_, err := DeleteRoleToken(config, programmaticAPIKeyID)
-		if err != nil {
-			return nil, err
-		}
-	case "rac":
-		err := DeleteRelayToken(config, programmaticAPIKeyID)
-		if err != nil {
+	if err := b.revoke(ctx, req.Storage, entry); err != nil {
+		// The lease goes away either way, so keep retrying on our side.
+		if queueErr := b.queueRevocation(ctx, req.Storage, entry, err); queueErr != nil {
 			return nil, err
 		}
+		b.Logger().Warn("revocation failed, queued for retry", "id", entry.ID, "connection", entry.Connection, "error", err)
 	}
 
 	return nil, nil
 }
 
 func (b *backend) programmaticAPIKeysRenew(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	config, err := getConfig(b, ctx, req.Storage)
+	config, err := getConfig(b, ctx, req.Storage, secretConnection(req.Secret))
+	if err != nil {
+		return nil, err
+	}
+
+	var role *launchdarklyRoleEntry
+	if name, ok := req.Secret.InternalData["role"].(string); ok && name != "" {
+		role, err = b.role(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			return logical.ErrorResponse(fmt.Sprintf("role %q no longer exists", name)), nil
+		}
+	}
+	limits := secretLimits(config, role)
+	if !limits.Renewable {
+		return logical.ErrorResponse("the lease is not renewable"), nil
+	}
+
+	// The max TTL the secret was issued with still applies if the role has
+	// since been given a longer one.
+	explicitMaxTTL := req.Secret.MaxTTL
+	if limits.Period > 0 {
+		explicitMaxTTL = 0
+	}
+	ttl, warnings, err := framework.CalculateTTL(b.System(), req.Secret.Increment, limits.TTL, limits.Period, limits.MaxTTL, explicitMaxTTL, req.Secret.IssueTime)
 	if err != nil {
 		return nil, err
 	}
-	resp := &logical.Response{Secret: req.Secret}
-	resp.Secret.TTL = config.TTL
-	resp.Secret.MaxTTL = config.MaxTTL
+
+	resp := &logical.Response{Secret: req.Secret, Warnings: warnings}
+	resp.Secret.TTL = ttl
+	if limits.Period == 0 {
+		resp.Secret.MaxTTL = limits.MaxTTL
+	}
+
+	if id, ok := req.Secret.InternalData["api_key_id"].(string); ok {
+		if err := b.updateIssuedExpiry(ctx, req.Storage, id, b.leaseExpiry(resp.Secret.TTL)); err != nil {
+			return nil, err
+		}
+	}
 	return resp, nil
 }
 
+// secretConnection returns the connection a secret was issued through. Secrets
+// issued before named connections existed belong to the default connection.
+func secretConnection(secret *logical.Secret) string {
+	if connection, ok := secret.InternalData["connection"].(string); ok && connection != "" {
+		return connection
+	}
+	return defaultConnection
+}
+
 const backendHelp = `
 The LaunchDarkly secrets engine generates LaunchDarkly tokens.
 `
@@ -1,5 +1,70 @@
 package launchdarkly
 
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+	ldapi "github.com/launchdarkly/api-client-go"
+)
+
+func TestClientCache(t *testing.T) {
+	e, err := newTestAccEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := e.Backend.(*backend)
+
+	writeConfig := func(path, token string) {
+		resp, err := b.HandleRequest(e.Context, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   e.Storage,
+			Data: map[string]interface{}{
+				"access_token": token,
+				"skip_verify":  true,
+			},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+		}
+	}
+	writeConfig("config", "api-first")
+	writeConfig("config/sandbox", "api-sandbox")
+
+	first, err := b.client(e.Context, e.Storage, defaultConnection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sandbox, err := b.client(e.Context, e.Storage, "sandbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := b.client(e.Context, e.Storage, defaultConnection); again != first {
+		t.Fatal("expected the client to be cached")
+	}
+
+	writeConfig("config", "api-second")
+	second, err := b.client(e.Context, e.Storage, defaultConnection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first || second.config.AccessToken != "api-second" {
+		t.Fatal("expected the client to be rebuilt after a config write")
+	}
+	if again, _ := b.client(e.Context, e.Storage, "sandbox"); again != sandbox {
+		t.Fatal("expected other connections to keep their client")
+	}
+
+	b.invalidate(e.Context, "config/sandbox")
+	if again, _ := b.client(e.Context, e.Storage, "sandbox"); again == sandbox {
+		t.Fatal("expected the client to be rebuilt after invalidation")
+	}
+}
+
 // func testBackend(tb testing.TB) (*backend, logical.Storage) {
 // 	tb.Helper()
 
@@ -112,3 +177,49 @@ package launchdarkly
 // 		}
 // 	})
 // }
+
+func TestPeriodicFuncReplication(t *testing.T) {
+	for state, maintained := range map[consts.ReplicationState]bool{
+		consts.ReplicationUnknown:              true,
+		consts.ReplicationPerformanceStandby:   false,
+		consts.ReplicationPerformanceSecondary: false,
+		consts.ReplicationDRSecondary:          false,
+	} {
+		resets := 0
+		server := newFakeLD(t, map[string]http.HandlerFunc{
+			"/api/v2/tokens/root/reset": func(w http.ResponseWriter, r *http.Request) {
+				resets++
+				writeJSON(w, http.StatusOK, ldapi.Token{Id: "root", Token: "api-rotated"})
+			},
+		})
+
+		ctx := context.Background()
+		storage := &logical.InmemStorage{}
+		b, err := Factory(ctx, &logical.BackendConfig{
+			System: &logical.StaticSystemView{ReplicationStateVal: state},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry, err := logical.StorageEntryJSON(configStorageKey(defaultConnection), &launchdarklyConfig{
+			AccessToken:    "api-1234",
+			AccessTokenID:  "root",
+			BaseUri:        server.URL,
+			AuthType:       authTypeAPIKey,
+			RotationPeriod: time.Hour,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Put(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := b.(*backend).periodicFunc(ctx, &logical.Request{Storage: storage}); err != nil {
+			t.Fatal(err)
+		}
+		if (resets == 1) != maintained {
+			t.Fatalf("replication state %d: expected maintenance: %v, got %d rotations", state, maintained, resets)
+		}
+	}
+}
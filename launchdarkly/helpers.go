@@ -2,17 +2,23 @@ package launchdarkly
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/hashicorp/go-cleanhttp"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
 
 	ldapi "github.com/launchdarkly/api-client-go"
 )
@@ -40,6 +46,54 @@ func validateFields(req *logical.Request, data *framework.FieldData) error {
 	return nil
 }
 
+// listFields returns the paging fields shared by the LIST endpoints.
+func listFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"after": {
+			Type:        framework.TypeString,
+			Description: "Optional entry to begin listing after, not required to exist.",
+		},
+		"limit": {
+			Type:        framework.TypeInt,
+			Description: "Optional number of entries to return; defaults to all entries.",
+		},
+	}
+}
+
+// listStorage lists the keys stored under prefix, paged by the after and limit
+// fields of the request.
+func listStorage(ctx context.Context, s logical.Storage, prefix string, data *framework.FieldData) (*logical.Response, error) {
+	keys, err := s.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return listKeys(keys, data)
+}
+
+// listKeys pages through keys using the after and limit fields of the request.
+func listKeys(keys []string, data *framework.FieldData) (*logical.Response, error) {
+	sort.Strings(keys)
+
+	if after := data.Get("after").(string); after != "" {
+		idx := sort.SearchStrings(keys, after)
+		if idx < len(keys) && keys[idx] == after {
+			idx++
+		}
+		keys = keys[idx:]
+	}
+
+	limit := data.Get("limit").(int)
+	if limit < 0 {
+		return logical.ErrorResponse("limit cannot be negative"), nil
+	}
+	if limit > 0 && limit < len(keys) {
+		keys = keys[:limit]
+	}
+
+	return logical.ListResponse(keys), nil
+}
+
 const (
 	//APIVersion = "20191212"
 	APIVersion = "beta"
@@ -50,32 +104,124 @@ type Client struct {
 	apiKey  string
 	apiHost string
 	ld      *ldapi.APIClient
-	ctx     context.Context
+	config  *launchdarklyConfig
+	http    *http.Client
+
+	// tokenSource is set when the connection authenticates with OAuth client
+	// credentials, so that the access token is shared by all requests.
+	tokenSource oauth2.TokenSource
 }
 
-func newClient(config *launchdarklyConfig, oauth bool) (*Client, error) {
+func newClient(config *launchdarklyConfig) (*Client, error) {
+	return newClientWithLimiter(config, newRateLimiter(config))
+}
+
+// newConnectionClient builds a client that shares the rate limiter of the
+// connection.
+func (b *backend) newConnectionClient(connection string, config *launchdarklyConfig) (*Client, error) {
+	return newClientWithLimiter(config, b.rateLimiter(connection, config))
+}
+
+func newClientWithLimiter(config *launchdarklyConfig, limiter *rateLimiter) (*Client, error) {
 	basePath := fmt.Sprintf(`%s/api/v2`, config.BaseUri)
 
+	httpClient, err := newHTTPClient(config, limiter)
+	if err != nil {
+		return nil, err
+	}
+
 	cfg := &ldapi.Configuration{
 		BasePath:      basePath,
 		DefaultHeader: make(map[string]string),
 		UserAgent:     fmt.Sprintf("launchdarkly-vault-provider/%s", Version),
+		HTTPClient:    httpClient,
 	}
 
-	cfg.AddDefaultHeader("LD-API-Version", APIVersion)
-
-	ctx := context.WithValue(context.Background(), ldapi.ContextAPIKey, ldapi.APIKey{
-		Key: config.AccessToken,
-	})
-	if oauth {
-		ctx = context.WithValue(context.Background(), ldapi.ContextAccessToken, config.AccessToken)
+	for k, v := range config.Headers {
+		cfg.AddDefaultHeader(k, v)
 	}
+	cfg.AddDefaultHeader("LD-API-Version", APIVersion)
 
-	return &Client{
+	client := &Client{
 		apiKey:  config.AccessToken,
 		apiHost: basePath,
 		ld:      ldapi.NewAPIClient(cfg),
-		ctx:     ctx,
+		config:  config,
+		http:    httpClient,
+	}
+	if config.AuthType == authTypeOAuth && config.OAuthClientID != "" {
+		client.tokenSource = config.oauthTokenSource(httpClient)
+	}
+	return client, nil
+}
+
+// authContext returns ctx with the credentials the ldapi client expects, so
+// that LaunchDarkly calls end with the Vault request that made them.
+func (c *Client) authContext(ctx context.Context) context.Context {
+	switch {
+	case c.tokenSource != nil:
+		return context.WithValue(ctx, ldapi.ContextOAuth2, c.tokenSource)
+	case c.config.AuthType == authTypeOAuth:
+		return context.WithValue(ctx, ldapi.ContextAccessToken, c.config.AccessToken)
+	default:
+		return context.WithValue(ctx, ldapi.ContextAPIKey, ldapi.APIKey{
+			Key: c.config.AccessToken,
+		})
+	}
+}
+
+// close releases the idle connections of the client.
+func (c *Client) close() {
+	if c.http == nil {
+		return
+	}
+	if transport, ok := c.http.Transport.(*rateLimitedTransport); ok {
+		transport.base.CloseIdleConnections()
+	}
+}
+
+// defaultRequestTimeout bounds LaunchDarkly calls when the connection does not
+// set request_timeout.
+const defaultRequestTimeout = 30 * time.Second
+
+// newHTTPClient builds the HTTP client used to reach LaunchDarkly, applying the
+// CA, proxy and timeout settings of the connection and its rate limiter.
+func newHTTPClient(config *launchdarklyConfig, limiter *rateLimiter) (*http.Client, error) {
+	transport := cleanhttp.DefaultPooledTransport()
+
+	if config.CACert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(config.CACert)) {
+			return nil, errors.New("ca_cert does not contain a valid PEM certificate")
+		}
+		transport.TLSClientConfig = &tls.Config{
+			RootCAs:    pool,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	if config.ProxyURL != "" {
+		proxyURL, err := url.Parse(config.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy_url: %v", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	timeout := config.RequestTimeout
+	if timeout <= 0 {
+		timeout = defaultRequestTimeout
+	}
+
+	return &http.Client{
+		Transport: &rateLimitedTransport{
+			base:    transport,
+			limiter: limiter,
+		},
+		Timeout: timeout,
 	}, nil
 }
 
@@ -91,54 +237,117 @@ func currentMillis() int64 {
 const (
 	MAX_409_RETRIES = 5
 	MAX_429_RETRIES = 10
+	MAX_5XX_RETRIES = 5
 )
 
-func handleRateLimit(apiCall func() (interface{}, *http.Response, error)) (interface{}, *http.Response, error) {
-	obj, res, err := apiCall()
-	for retryCount := 0; res != nil && res.StatusCode == http.StatusTooManyRequests && retryCount < MAX_429_RETRIES; retryCount++ {
-		log.Println("[DEBUG] received a 429 Too Many Requests error. retrying")
-		resetStr := res.Header.Get("X-RateLimit-Reset")
-		resetInt, parseErr := strconv.ParseInt(resetStr, 10, 64)
-		if parseErr != nil {
-			log.Println("[DEBUG] could not parse X-RateLimit-Reset header. Sleeping for a random interval.")
-			randomRetrySleep()
-		} else {
-			resetTime := time.Unix(0, resetInt*int64(time.Millisecond))
-			sleepDuration := time.Until(resetTime)
-
-			// We have observed situations where LD-s retry header results in a negative sleep duration. In this case,
-			// multiply the duration by -1 and add a random 200-500ms
-			if sleepDuration <= 0 {
-				log.Printf("[DEBUG] received a negative rate limit retry duration of %s. Sleeping for an additional 200-500ms", sleepDuration)
-				sleepDuration = -1*sleepDuration + getRandomSleepDuration()
-			}
-			log.Println("[DEBUG] sleeping", sleepDuration)
-			time.Sleep(sleepDuration)
+var (
+	// retryBaseDelay and retryMaxDelay bound the exponential backoff used for
+	// server and network errors.
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+)
+
+// handleRateLimit calls apiCall until it succeeds or fails in a way that
+// retrying cannot fix. 429 responses wait for X-RateLimit-Reset. 409 responses
+// are only retried when retryConflicts is set, for calls that are safe to
+// repeat. 5xx responses and network errors may come after LaunchDarkly applied
+// the call, so they are only retried, with an exponential backoff and jitter,
+// when idempotent is set: repeating a create would leave a duplicate behind and
+// repeating a reset would invalidate the value the first one returned. Retries
+// stop as soon as ctx is done.
+func handleRateLimit(ctx context.Context, retryConflicts, idempotent bool, apiCall func() (interface{}, *http.Response, error)) (interface{}, *http.Response, error) {
+	var count429, count409, count5xx int
+	for {
+		obj, res, err := apiCall()
+
+		var sleepDuration time.Duration
+		switch {
+		case ctx.Err() != nil:
+			return obj, res, err
+		case res != nil && res.StatusCode == http.StatusTooManyRequests && count429 < MAX_429_RETRIES:
+			count429++
+			log.Println("[DEBUG] received a 429 Too Many Requests error. retrying")
+			sleepDuration = rateLimitResetDuration(res)
+		case res != nil && res.StatusCode == http.StatusConflict && retryConflicts && count409 < MAX_409_RETRIES:
+			count409++
+			log.Println("[DEBUG] received a 409 Conflict error. retrying")
+			sleepDuration = backoffDuration(count409)
+		case res != nil && res.StatusCode >= http.StatusInternalServerError && idempotent && count5xx < MAX_5XX_RETRIES:
+			count5xx++
+			log.Printf("[DEBUG] received a %d error. retrying", res.StatusCode)
+			sleepDuration = backoffDuration(count5xx)
+		case res == nil && err != nil && idempotent && isTransientErr(err) && count5xx < MAX_5XX_RETRIES:
+			count5xx++
+			log.Printf("[DEBUG] request failed: %s. retrying", err)
+			sleepDuration = backoffDuration(count5xx)
+		default:
+			return obj, res, err
+		}
+
+		log.Println("[DEBUG] sleeping", sleepDuration)
+		timer := time.NewTimer(sleepDuration)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return obj, res, err
 		}
-		obj, res, err = apiCall()
 	}
-	return obj, res, err
+}
 
+// isTransientErr reports whether a request that failed before a response was
+// received is worth retrying. Certificate errors will fail the same way again.
+func isTransientErr(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalidCert x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	switch {
+	case errors.As(err, &unknownAuthority), errors.As(err, &invalidCert), errors.As(err, &hostname):
+		return false
+	}
+	return true
 }
 
-var randomRetrySleepSeeded = false
+// rateLimitResetDuration returns how long to wait before retrying a 429 response.
+func rateLimitResetDuration(res *http.Response) time.Duration {
+	resetStr := res.Header.Get("X-RateLimit-Reset")
+	resetInt, parseErr := strconv.ParseInt(resetStr, 10, 64)
+	if parseErr != nil {
+		log.Println("[DEBUG] could not parse X-RateLimit-Reset header. Sleeping for a random interval.")
+		return getRandomSleepDuration()
+	}
 
-// Sleep for a random interval between 200ms and 500ms
-func getRandomSleepDuration() time.Duration {
-	if !randomRetrySleepSeeded {
-		rand.Seed(time.Now().UnixNano())
+	resetTime := time.Unix(0, resetInt*int64(time.Millisecond))
+	sleepDuration := time.Until(resetTime)
+
+	// We have observed situations where LD-s retry header results in a negative sleep duration. In this case,
+	// multiply the duration by -1 and add a random 200-500ms
+	if sleepDuration <= 0 {
+		log.Printf("[DEBUG] received a negative rate limit retry duration of %s. Sleeping for an additional 200-500ms", sleepDuration)
+		sleepDuration = -1*sleepDuration + getRandomSleepDuration()
 	}
-	n := rand.Intn(300) + 200
-	return time.Duration(n) * time.Millisecond
+	return sleepDuration
 }
 
-// Sleep for a random interval between 200ms and 500ms
-func randomRetrySleep() {
-	if !randomRetrySleepSeeded {
-		rand.Seed(time.Now().UnixNano())
+// backoffDuration returns the exponential backoff for the given retry, with
+// jitter so that concurrent callers do not retry in lockstep.
+func backoffDuration(retry int) time.Duration {
+	backoff := retryBaseDelay << uint(retry-1)
+	if backoff <= 0 || backoff > retryMaxDelay {
+		backoff = retryMaxDelay
 	}
+	seedRetrySleep.Do(func() { rand.Seed(time.Now().UnixNano()) })
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// seedRetrySleep seeds the random retry sleeps once per process.
+var seedRetrySleep sync.Once
+
+// Sleep for a random interval between 200ms and 500ms
+func getRandomSleepDuration() time.Duration {
+	seedRetrySleep.Do(func() { rand.Seed(time.Now().UnixNano()) })
 	n := rand.Intn(300) + 200
-	time.Sleep(time.Duration(n) * time.Millisecond)
+	return time.Duration(n) * time.Millisecond
 }
 
 // handleLdapiErr extracts the error message and body from a ldapi.GenericSwaggerError or simply returns the
@@ -157,6 +366,15 @@ func configCheck(config *launchdarklyConfig) error {
 	if config == nil {
 		return errors.New("Please write your AccessToken to launchdarkly/config")
 	}
+	if config.AuthType == authTypeOAuth && config.OAuthClientID != "" {
+		if config.OAuthClientSecret == "" {
+			return errors.New("LaunchDarkly OAuth client secret needs to be set")
+		}
+		if config.BaseUri == "" {
+			return errors.New("LaunchDarkly BaseUri needs to be set")
+		}
+		return nil
+	}
 	if config.AccessToken == "" && config.BaseUri == "" {
 		return errors.New("Access Token and BaseUri need to be set")
 	}
@@ -169,8 +387,8 @@ func configCheck(config *launchdarklyConfig) error {
 	return nil
 }
 
-func getConfig(b *backend, ctx context.Context, storage logical.Storage) (*launchdarklyConfig, error) {
-	config, err := b.config(ctx, storage)
+func getConfig(b *backend, ctx context.Context, storage logical.Storage, connection string) (*launchdarklyConfig, error) {
+	config, err := b.config(ctx, storage, connection)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,157 @@
+package launchdarkly
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	defer func(base time.Duration) { retryBaseDelay = base }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	ctx := context.Background()
+	respond := func(statuses ...int) (func() (interface{}, *http.Response, error), *int) {
+		calls := 0
+		return func() (interface{}, *http.Response, error) {
+			status := statuses[calls]
+			calls++
+			res := &http.Response{StatusCode: status, Header: http.Header{}}
+			if status == http.StatusTooManyRequests {
+				reset := time.Now().Add(10*time.Millisecond).UnixNano() / int64(time.Millisecond)
+				res.Header.Set("X-RateLimit-Reset", strconv.FormatInt(reset, 10))
+			}
+			if status >= 400 {
+				return nil, res, errors.New(http.StatusText(status))
+			}
+			return "ok", res, nil
+		}, &calls
+	}
+
+	t.Run("server errors", func(t *testing.T) {
+		call, calls := respond(503, 502, 200)
+		obj, _, err := handleRateLimit(ctx, false, true, call)
+		if err != nil || obj != "ok" || *calls != 3 {
+			t.Fatalf("bad: obj: %v, calls: %d, err: %v", obj, *calls, err)
+		}
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		call, calls := respond(429, 200)
+		if _, _, err := handleRateLimit(ctx, false, true, call); err != nil || *calls != 2 {
+			t.Fatalf("bad: calls: %d, err: %v", *calls, err)
+		}
+	})
+
+	t.Run("conflicts", func(t *testing.T) {
+		call, calls := respond(409, 200)
+		if _, _, err := handleRateLimit(ctx, false, true, call); err == nil || *calls != 1 {
+			t.Fatalf("expected no retry, calls: %d, err: %v", *calls, err)
+		}
+		call, calls = respond(409, 200)
+		if _, _, err := handleRateLimit(ctx, true, true, call); err != nil || *calls != 2 {
+			t.Fatalf("bad: calls: %d, err: %v", *calls, err)
+		}
+	})
+
+	t.Run("client errors", func(t *testing.T) {
+		call, calls := respond(400, 200)
+		if _, _, err := handleRateLimit(ctx, true, true, call); err == nil || *calls != 1 {
+			t.Fatalf("expected no retry, calls: %d, err: %v", *calls, err)
+		}
+	})
+
+	t.Run("gives up", func(t *testing.T) {
+		call, calls := respond(500, 500, 500, 500, 500, 500, 200)
+		if _, _, err := handleRateLimit(ctx, false, true, call); err == nil || *calls != MAX_5XX_RETRIES+1 {
+			t.Fatalf("bad: calls: %d, err: %v", *calls, err)
+		}
+	})
+
+	t.Run("network errors", func(t *testing.T) {
+		calls := 0
+		_, _, err := handleRateLimit(ctx, false, true, func() (interface{}, *http.Response, error) {
+			calls++
+			if calls == 1 {
+				return nil, nil, errors.New("connection reset by peer")
+			}
+			return nil, &http.Response{StatusCode: 204}, nil
+		})
+		if err != nil || calls != 2 {
+			t.Fatalf("bad: calls: %d, err: %v", calls, err)
+		}
+	})
+
+	t.Run("not idempotent", func(t *testing.T) {
+		call, calls := respond(503, 200)
+		if _, _, err := handleRateLimit(ctx, false, false, call); err == nil || *calls != 1 {
+			t.Fatalf("expected no retry, calls: %d, err: %v", *calls, err)
+		}
+		calls2 := 0
+		if _, _, err := handleRateLimit(ctx, false, false, func() (interface{}, *http.Response, error) {
+			calls2++
+			return nil, nil, errors.New("connection reset by peer")
+		}); err == nil || calls2 != 1 {
+			t.Fatalf("expected no retry, calls: %d, err: %v", calls2, err)
+		}
+		call, calls = respond(429, 200)
+		if _, _, err := handleRateLimit(ctx, false, false, call); err != nil || *calls != 2 {
+			t.Fatalf("bad: calls: %d, err: %v", *calls, err)
+		}
+	})
+
+	t.Run("cancelled while waiting", func(t *testing.T) {
+		waiting, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, _, err := handleRateLimit(waiting, false, true, func() (interface{}, *http.Response, error) {
+			res := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+			reset := time.Now().Add(time.Minute).UnixNano() / int64(time.Millisecond)
+			res.Header.Set("X-RateLimit-Reset", strconv.FormatInt(reset, 10))
+			return nil, res, errors.New("rate limited")
+		})
+		if err == nil || time.Since(start) > time.Second {
+			t.Fatalf("expected the wait to end with the context, err: %v", err)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		call, calls := respond(503, 200)
+		if _, _, err := handleRateLimit(cancelled, false, true, call); err == nil || *calls != 1 {
+			t.Fatalf("expected no retry, calls: %d, err: %v", *calls, err)
+		}
+	})
+}
+
+func TestRequestContext(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	server := newFakeLD(t, map[string]http.HandlerFunc{
+		"/api/v2/tokens": func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		},
+	})
+
+	client, err := newClient(&launchdarklyConfig{AccessToken: "api-1234", BaseUri: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := CreateRoleToken(ctx, client, &launchdarklyRoleEntry{TokenName: "test"}, "test"); err == nil {
+		t.Fatal("expected the call to end with the request context")
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("the LaunchDarkly call outlived the request context")
+	}
+}
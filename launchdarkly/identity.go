@@ -0,0 +1,188 @@
+package launchdarkly
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	ldapi "github.com/launchdarkly/api-client-go"
+)
+
+// identityTemplateRegex matches the Vault identity templates that policy
+// resources can use, such as {{identity.entity.metadata.ld_project}}.
+var identityTemplateRegex = regexp.MustCompile(`{{\s*identity\.([^}]*?)\s*}}`)
+
+// identityValueForbidden are the characters of LaunchDarkly resource
+// specifiers. Rendered values cannot contain them, so that an entity cannot
+// widen the resources it is granted.
+const identityValueForbidden = ":/;,*{}[] \t\r\n"
+
+// usesIdentityTemplates reports whether any of the resources uses an identity
+// template.
+func usesIdentityTemplates(resources ...[]string) bool {
+	for _, values := range resources {
+		for _, value := range values {
+			if identityTemplateRegex.MatchString(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateIdentityTemplates checks that the identity templates of a resource
+// are ones that can be rendered.
+func validateIdentityTemplates(resource string) error {
+	for _, match := range identityTemplateRegex.FindAllStringSubmatch(resource, -1) {
+		if _, err := identityValue(match[1], nil); err != nil && !errors.Is(err, errNoEntity) {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderIdentityTemplates fills in the identity templates of the resources
+// with the entity of the request.
+func renderIdentityTemplates(resources []string, entity *logical.Entity) ([]string, error) {
+	if resources == nil {
+		return nil, nil
+	}
+
+	rendered := make([]string, len(resources))
+	for i, resource := range resources {
+		var renderErr error
+		rendered[i] = identityTemplateRegex.ReplaceAllStringFunc(resource, func(template string) string {
+			value, err := identityValue(identityTemplateRegex.FindStringSubmatch(template)[1], entity)
+			if err != nil && renderErr == nil {
+				renderErr = err
+			}
+			return value
+		})
+		if renderErr != nil {
+			return nil, renderErr
+		}
+	}
+	return rendered, nil
+}
+
+var errNoEntity = errors.New("the policy uses identity templates but the request has no identity entity")
+
+// identityValue returns the value of an identity template, given without its
+// "identity." prefix. Without an entity, it only checks the template.
+func identityValue(path string, entity *logical.Entity) (string, error) {
+	parts := strings.Split(path, ".")
+	if len(parts) < 2 || parts[0] != "entity" {
+		return "", fmt.Errorf("unsupported identity template %q", "identity."+path)
+	}
+
+	var value string
+	var found bool
+	switch {
+	case len(parts) == 2 && (parts[1] == "id" || parts[1] == "name"):
+		if entity == nil {
+			return "", errNoEntity
+		}
+		value, found = entity.ID, true
+		if parts[1] == "name" {
+			value = entity.Name
+		}
+	case len(parts) == 3 && parts[1] == "metadata":
+		if entity == nil {
+			return "", errNoEntity
+		}
+		value, found = entity.Metadata[parts[2]]
+	case len(parts) >= 4 && parts[1] == "aliases":
+		// identity.entity.aliases.<mount accessor>.name or .metadata.<key>
+		if !(len(parts) == 4 && parts[3] == "name") && !(len(parts) == 5 && parts[3] == "metadata") {
+			return "", fmt.Errorf("unsupported identity template %q", "identity."+path)
+		}
+		if entity == nil {
+			return "", errNoEntity
+		}
+		for _, alias := range entity.Aliases {
+			if alias.MountAccessor != parts[2] {
+				continue
+			}
+			if parts[3] == "name" {
+				value, found = alias.Name, true
+			} else {
+				value, found = alias.Metadata[parts[4]]
+			}
+		}
+	default:
+		return "", fmt.Errorf("unsupported identity template %q", "identity."+path)
+	}
+
+	if !found || value == "" {
+		return "", fmt.Errorf("identity.%s is not set for the requesting entity", path)
+	}
+	if strings.ContainsAny(value, identityValueForbidden) {
+		return "", fmt.Errorf("identity.%s of the requesting entity cannot be used in a resource: %q", path, value)
+	}
+	return value, nil
+}
+
+// requestEntity returns the identity entity the request was made with.
+func (b *backend) requestEntity(req *logical.Request) (*logical.Entity, error) {
+	if req.EntityID == "" {
+		return nil, errNoEntity
+	}
+	entity, err := b.System().EntityInfo(req.EntityID)
+	if err != nil {
+		return nil, err
+	}
+	if entity == nil {
+		return nil, errNoEntity
+	}
+	return entity, nil
+}
+
+// identityStatements fills in the identity templates of the resources of the
+// statements with the entity of the request.
+func (b *backend) identityStatements(req *logical.Request, statements []ldapi.Statement) ([]ldapi.Statement, error) {
+	uses := false
+	for _, statement := range statements {
+		uses = uses || usesIdentityTemplates(statement.Resources, statement.NotResources)
+	}
+	if !uses {
+		return statements, nil
+	}
+
+	entity, err := b.requestEntity(req)
+	if err != nil {
+		return nil, err
+	}
+	rendered := make([]ldapi.Statement, len(statements))
+	for i, statement := range statements {
+		if statement.Resources, err = renderIdentityTemplates(statement.Resources, entity); err != nil {
+			return nil, err
+		}
+		if statement.NotResources, err = renderIdentityTemplates(statement.NotResources, entity); err != nil {
+			return nil, err
+		}
+		rendered[i] = statement
+	}
+	return rendered, nil
+}
+
+// identityPolicy fills in the identity templates of the resources of a relay
+// policy with the entity of the request.
+func (b *backend) identityPolicy(req *logical.Request, policy ldapi.Policy) (ldapi.Policy, error) {
+	if !usesIdentityTemplates(policy.Resources, policy.NotResources) {
+		return policy, nil
+	}
+
+	entity, err := b.requestEntity(req)
+	if err != nil {
+		return policy, err
+	}
+	if policy.Resources, err = renderIdentityTemplates(policy.Resources, entity); err != nil {
+		return policy, err
+	}
+	if policy.NotResources, err = renderIdentityTemplates(policy.NotResources, entity); err != nil {
+		return policy, err
+	}
+	return policy, nil
+}
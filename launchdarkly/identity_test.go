@@ -0,0 +1,82 @@
+package launchdarkly
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	ldapi "github.com/launchdarkly/api-client-go"
+)
+
+func TestIdentityTemplates(t *testing.T) {
+	var token ldapi.TokenBody
+	var relay ldapi.RelayProxyConfigBody
+	server := newFakeLD(t, map[string]http.HandlerFunc{
+		"/api/v2/tokens": func(w http.ResponseWriter, r *http.Request) {
+			decodeJSON(t, r, &token)
+			writeJSON(w, http.StatusCreated, ldapi.Token{Id: "issued", Token: "api-issued"})
+		},
+		"/api/v2/account/relay-auto-configs": func(w http.ResponseWriter, r *http.Request) {
+			decodeJSON(t, r, &relay)
+			writeJSON(w, http.StatusCreated, ldapi.RelayProxyConfig{Id: "relay", FullKey: "rel-issued"})
+		},
+	})
+
+	e, err := newTestAccEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity := &logical.Entity{
+		ID:       "entity-1",
+		Name:     "mobile-team",
+		Metadata: map[string]string{"ld_project": "mobile", "wildcard": "*"},
+		Aliases: []*logical.Alias{
+			{MountAccessor: "auth_approle_1", Name: "ci", Metadata: map[string]string{"env": "staging"}},
+		},
+	}
+	e.Backend.(*backend).System().(*logical.StaticSystemView).EntityVal = entity
+
+	writeRole := func(name, resource string) {
+		t.Helper()
+		e.mustRequest(t, logical.UpdateOperation, "role/"+name, map[string]interface{}{
+			"inline_policy": `[{"effect": "allow", "resources": ["` + resource + `"], "actions": ["*"]}]`,
+		})
+	}
+
+	e.mustRequest(t, logical.UpdateOperation, "config", map[string]interface{}{
+		"access_token": "api-1234",
+		"base_uri":     server.URL,
+		"skip_verify":  true,
+	})
+
+	e.mustRefuse(t, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/groups",
+		Data: map[string]interface{}{
+			"inline_policy": `[{"effect": "allow", "resources": ["proj/{{identity.groups.names}}"], "actions": ["*"]}]`,
+		},
+	})
+
+	writeRole("team", "proj/{{identity.entity.metadata.ld_project}}:env/{{identity.entity.aliases.auth_approle_1.metadata.env}}")
+	e.mustHandle(t, &logical.Request{Operation: logical.ReadOperation, Path: "creds/team", EntityID: "entity-1"})
+	if expected := []string{"proj/mobile:env/staging"}; !reflect.DeepEqual(token.InlineRole[0].Resources, expected) {
+		t.Fatalf("expected the resources to be rendered, got %v", token.InlineRole[0].Resources)
+	}
+	e.mustRefuse(t, &logical.Request{Operation: logical.ReadOperation, Path: "creds/team"})
+
+	writeRole("missing", "proj/{{identity.entity.metadata.ld_team}}")
+	e.mustRefuse(t, &logical.Request{Operation: logical.ReadOperation, Path: "creds/missing", EntityID: "entity-1"})
+
+	writeRole("wildcard", "proj/{{identity.entity.metadata.wildcard}}")
+	e.mustRefuse(t, &logical.Request{Operation: logical.ReadOperation, Path: "creds/wildcard", EntityID: "entity-1"})
+
+	e.mustRequest(t, logical.UpdateOperation, "relay/policy", map[string]interface{}{
+		"name":          "team-relay",
+		"inline_policy": `{"effect": "allow", "resources": ["proj/{{identity.entity.metadata.ld_project}}:env/*"], "actions": ["*"]}`,
+	})
+	e.mustHandle(t, &logical.Request{Operation: logical.ReadOperation, Path: "relay/team-relay", EntityID: "entity-1"})
+	if expected := []string{"proj/mobile:env/*"}; len(relay.Policy) != 1 || !reflect.DeepEqual(relay.Policy[0].Resources, expected) {
+		t.Fatalf("expected the relay policy to be rendered, got %#v", relay.Policy)
+	}
+}
@@ -0,0 +1,355 @@
+package launchdarkly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	ldapi "github.com/launchdarkly/api-client-go"
+)
+
+const issuedPrefix = "issued/"
+
+// issuedEntry is the plugin's record of a LaunchDarkly credential with a live
+// lease. It is written when the credential is issued and removed once the
+// credential is deleted from LaunchDarkly.
+type issuedEntry struct {
+	ID             string `json:"id"`
+	CredentialType string `json:"credential_type"`
+	// SecretType is the path the credential was issued through: role,
+	// coderefs or relay.
+	SecretType  string `json:"secret_type"`
+	Role        string `json:"role,omitempty"`
+	Policy      string `json:"policy,omitempty"`
+	Project     string `json:"project,omitempty"`
+	Environment string `json:"environment,omitempty"`
+	Connection  string `json:"connection"`
+	// Name is the name of the credential in LaunchDarkly.
+	Name        string    `json:"name"`
+	EntityID    string    `json:"entity_id"`
+	DisplayName string    `json:"display_name"`
+	IssuedAt    time.Time `json:"issued_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+
+	// IdleTimeout is copied from the role. LastUsed is the last use reported
+	// by LaunchDarkly when it was last checked.
+	IdleTimeout time.Duration `json:"idle_timeout,omitempty"`
+	LastUsed    time.Time     `json:"last_used,omitempty"`
+
+	// RotatedAt is when the secret value was last reset in place.
+	RotatedAt time.Time `json:"rotated_at,omitempty"`
+}
+
+func (b *backend) putIssued(ctx context.Context, s logical.Storage, entry *issuedEntry) error {
+	if entry.IssuedAt.IsZero() {
+		entry.IssuedAt = time.Now()
+	}
+
+	storageEntry, err := logical.StorageEntryJSON(issuedPrefix+entry.ID, entry)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, storageEntry)
+}
+
+func (b *backend) issued(ctx context.Context, s logical.Storage, id string) (*issuedEntry, error) {
+	storageEntry, err := s.Get(ctx, issuedPrefix+id)
+	if err != nil {
+		return nil, err
+	}
+	if storageEntry == nil {
+		return nil, nil
+	}
+
+	entry := &issuedEntry{}
+	if err := storageEntry.DecodeJSON(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// issuedRecords returns the records of every issued credential, keyed by id.
+func (b *backend) issuedRecords(ctx context.Context, s logical.Storage) (map[string]*issuedEntry, error) {
+	ids, err := s.List(ctx, issuedPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]*issuedEntry)
+	for _, id := range ids {
+		entry, err := b.issued(ctx, s, id)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			entries[id] = entry
+		}
+	}
+	return entries, nil
+}
+
+// updateIssuedExpiry records the new expiry of a renewed lease.
+func (b *backend) updateIssuedExpiry(ctx context.Context, s logical.Storage, id string, expiresAt time.Time) error {
+	entry, err := b.issued(ctx, s, id)
+	if err != nil || entry == nil {
+		return err
+	}
+	entry.ExpiresAt = expiresAt
+	return b.putIssued(ctx, s, entry)
+}
+
+// leaseExpiry returns when a lease with the given TTL issued now expires.
+func (b *backend) leaseExpiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		ttl = b.System().DefaultLeaseTTL()
+	}
+	return time.Now().Add(ttl)
+}
+
+func (b *backend) pathIssuedList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return listStorage(ctx, req.Storage, issuedPrefix, data)
+}
+
+func (b *backend) pathIssuedRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entry, err := b.issued(ctx, req.Storage, data.Get("id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	resp := map[string]interface{}{
+		"id":              entry.ID,
+		"credential_type": entry.CredentialType,
+		"secret_type":     entry.SecretType,
+		"connection":      entry.Connection,
+		"name":            entry.Name,
+		"entity_id":       entry.EntityID,
+		"display_name":    entry.DisplayName,
+		"issued_at":       entry.IssuedAt.Format(time.RFC3339),
+		"expires_at":      entry.ExpiresAt.Format(time.RFC3339),
+	}
+	if entry.Role != "" {
+		resp["role"] = entry.Role
+	}
+	if entry.Policy != "" {
+		resp["policy"] = entry.Policy
+	}
+	if entry.Project != "" {
+		resp["project"] = entry.Project
+	}
+	if entry.Environment != "" {
+		resp["environment"] = entry.Environment
+	}
+	if entry.IdleTimeout != 0 {
+		resp["idle_timeout"] = int64(entry.IdleTimeout.Seconds())
+	}
+	if !entry.RotatedAt.IsZero() {
+		resp["rotated_at"] = entry.RotatedAt.Format(time.RFC3339)
+	}
+
+	var warnings []string
+	if entry.CredentialType == "api" {
+		if lastUsed, err := b.tokenLastUsed(ctx, req.Storage, entry); err != nil {
+			warnings = append(warnings, "could not read the last use of the token from LaunchDarkly: "+err.Error())
+		} else if !lastUsed.IsZero() {
+			resp["last_used"] = lastUsed.Format(time.RFC3339)
+		} else {
+			resp["last_used"] = ""
+		}
+	}
+
+	return &logical.Response{
+		Data:     resp,
+		Warnings: warnings,
+	}, nil
+}
+
+// tokenLastUsed returns when LaunchDarkly last saw the token of the entry used,
+// or the zero time if it never was.
+func (b *backend) tokenLastUsed(ctx context.Context, s logical.Storage, entry *issuedEntry) (time.Time, error) {
+	client, err := b.client(ctx, s, entry.Connection)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	tokenRaw, _, err := handleRateLimit(ctx, true, true, func() (interface{}, *http.Response, error) {
+		return client.ld.AccessTokensApi.GetToken(client.authContext(ctx), entry.ID)
+	})
+	if err != nil {
+		return time.Time{}, handleLdapiErr(err)
+	}
+
+	token := tokenRaw.(ldapi.Token)
+	if token.LastUsed == 0 {
+		return time.Time{}, nil
+	}
+	return millisToTime(token.LastUsed), nil
+}
+
+// revokeIdleCredentials deletes the tokens that have not been used within the
+// idle_timeout of their role. LaunchDarkly is only asked for the last use once
+// the last known use is older than the timeout.
+func (b *backend) revokeIdleCredentials(ctx context.Context, s logical.Storage) error {
+	ids, err := s.List(ctx, issuedPrefix)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			break
+		}
+
+		entry, err := b.issued(ctx, s, id)
+		if err != nil {
+			return err
+		}
+		if entry == nil || entry.IdleTimeout <= 0 || entry.CredentialType != "api" || !entry.idleSince().Before(time.Now().Add(-entry.IdleTimeout)) {
+			continue
+		}
+		if queued, err := b.revokeQueueEntry(ctx, s, id); err != nil {
+			return err
+		} else if queued != nil {
+			// Already being retried by the revocation queue.
+			continue
+		}
+
+		lastUsed, err := b.tokenLastUsed(ctx, s, entry)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", id, err))
+			continue
+		}
+		if lastUsed.After(entry.LastUsed) {
+			entry.LastUsed = lastUsed
+			if entry.idleSince().After(time.Now().Add(-entry.IdleTimeout)) {
+				if err := b.putIssued(ctx, s, entry); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		b.Logger().Info("deleting an idle token", "id", id, "role", entry.Role, "idle_timeout", entry.IdleTimeout, "last_used", entry.LastUsed)
+		revocation := &revokeQueueEntry{
+			ID:             entry.ID,
+			CredentialType: entry.CredentialType,
+			Connection:     entry.Connection,
+		}
+		if err := b.revoke(ctx, s, revocation); err != nil {
+			if queueErr := b.queueRevocation(ctx, s, revocation, err); queueErr != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", id, queueErr))
+				continue
+			}
+			b.Logger().Warn("could not delete an idle token, queued for retry", "id", id, "connection", entry.Connection, "error", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("could not revoke idle tokens: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// idleSince returns the last known use of the credential, or its issue time.
+func (entry *issuedEntry) idleSince() time.Time {
+	if entry.LastUsed.After(entry.IssuedAt) {
+		return entry.LastUsed
+	}
+	return entry.IssuedAt
+}
+
+// pathIssuedRevoke deletes an issued credential from LaunchDarkly. Its lease is
+// left to expire, and its revocation then finds nothing left to delete.
+func (b *backend) pathIssuedRevoke(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	id := data.Get("id").(string)
+	if id == "" {
+		return nil, errors.New("id is required")
+	}
+
+	entry, err := b.issued(ctx, req.Storage, id)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse("unknown issued credential: " + id), nil
+	}
+
+	if err := b.revoke(ctx, req.Storage, &revokeQueueEntry{
+		ID:             entry.ID,
+		CredentialType: entry.CredentialType,
+		Connection:     entry.Connection,
+	}); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// pathIssuedRotate resets the secret value of an issued credential in place.
+// The credential keeps its LaunchDarkly id, custom roles or policy, and lease.
+func (b *backend) pathIssuedRotate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if err := validateFields(req, data); err != nil {
+		return nil, logical.CodedError(422, err.Error())
+	}
+
+	id := data.Get("id").(string)
+	entry, err := b.issued(ctx, req.Storage, id)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse("unknown issued credential: " + id), nil
+	}
+
+	var expiry time.Time
+	if d := time.Duration(data.Get("old_value_ttl").(int)) * time.Second; d > 0 {
+		expiry = time.Now().Add(d)
+	}
+
+	client, err := b.client(ctx, req.Storage, entry.Connection)
+	if err != nil {
+		return nil, err
+	}
+
+	var value string
+	switch entry.CredentialType {
+	case "api":
+		token, err := ResetRoleToken(ctx, client, entry.ID, expiry)
+		if err != nil {
+			return nil, err
+		}
+		value = token.Token
+	case "rac":
+		token, err := ResetRelayToken(ctx, client, entry.ID, expiry)
+		if err != nil {
+			return nil, err
+		}
+		value = token.FullKey
+	default:
+		return nil, fmt.Errorf("unknown credential type %q", entry.CredentialType)
+	}
+	b.Logger().Info("rotated an issued credential", "id", entry.ID, "credential_type", entry.CredentialType, "old_value_expires_at", expiry)
+
+	entry.RotatedAt = time.Now()
+	if err := b.putIssued(ctx, req.Storage, entry); err != nil {
+		return nil, err
+	}
+
+	resp := map[string]interface{}{
+		"token":           value,
+		"api_key_id":      entry.ID,
+		"credential_type": entry.CredentialType,
+		"rotated_at":      entry.RotatedAt.Format(time.RFC3339),
+	}
+	if !expiry.IsZero() {
+		resp["old_value_expires_at"] = expiry.Format(time.RFC3339)
+	}
+	return &logical.Response{Data: resp}, nil
+}
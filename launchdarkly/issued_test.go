@@ -0,0 +1,235 @@
+package launchdarkly
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	ldapi "github.com/launchdarkly/api-client-go"
+)
+
+func TestIssued(t *testing.T) {
+	lastUsed := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	var deleted []string
+	server := newFakeLD(t, map[string]http.HandlerFunc{
+		"/api/v2/tokens": func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusCreated, ldapi.Token{Id: "issued", Name: "vault-generated", Token: "api-issued"})
+		},
+		"/api/v2/tokens/issued": func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				writeJSON(w, http.StatusOK, ldapi.Token{Id: "issued", LastUsed: lastUsed.UnixNano() / int64(time.Millisecond)})
+				return
+			}
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		},
+	})
+
+	e, err := newTestAccEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.mustHandle(t, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Data: map[string]interface{}{
+			"access_token": "api-1234",
+			"base_uri":     server.URL,
+			"skip_verify":  true,
+		},
+	})
+	e.mustHandle(t, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/" + roleTestName,
+		Data: map[string]interface{}{
+			"custom_role_ids": []string{"reader"},
+			"ttl":             "30m",
+		},
+	})
+	e.mustHandle(t, &logical.Request{
+		Operation:   logical.ReadOperation,
+		Path:        "creds/" + roleTestName,
+		EntityID:    "entity-1",
+		DisplayName: "approle-ci",
+	})
+
+	resp := e.mustHandle(t, &logical.Request{Operation: logical.ListOperation, Path: "issued/"})
+	if keys := resp.Data["keys"].([]string); len(keys) != 1 || keys[0] != "issued" {
+		t.Fatalf("unexpected issued credentials: %v", keys)
+	}
+
+	resp = e.mustHandle(t, &logical.Request{Operation: logical.ReadOperation, Path: "issued/issued"})
+	for k, v := range map[string]interface{}{
+		"credential_type": "api",
+		"secret_type":     "role",
+		"role":            roleTestName,
+		"connection":      defaultConnection,
+		"name":            "vault-generated",
+		"entity_id":       "entity-1",
+		"display_name":    "approle-ci",
+	} {
+		if resp.Data[k] != v {
+			t.Fatalf("expected %s to be %v, got %#v", k, v, resp.Data[k])
+		}
+	}
+	expiresAt, err := time.Parse(time.RFC3339, resp.Data["expires_at"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(expiresAt); d < 29*time.Minute || d > 30*time.Minute {
+		t.Fatalf("expected the lease to expire in 30m, got %v", d)
+	}
+	if resp.Data["last_used"] != "2020-06-01T12:00:00Z" {
+		t.Fatalf("unexpected last_used: %#v", resp.Data["last_used"])
+	}
+
+	e.mustHandle(t, &logical.Request{Operation: logical.UpdateOperation, Path: "issued/issued/revoke"})
+	if len(deleted) != 1 {
+		t.Fatalf("expected the token to be deleted, got %v", deleted)
+	}
+	if resp := e.mustHandle(t, &logical.Request{Operation: logical.ReadOperation, Path: "issued/issued"}); resp != nil {
+		t.Fatalf("expected the record to be removed, got %#v", resp.Data)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	lastUsed := map[string]time.Time{
+		"idle":   time.Now().Add(-3 * time.Hour),
+		"active": time.Now().Add(-10 * time.Minute),
+		"recent": time.Now().Add(-3 * time.Hour),
+		"stuck":  time.Now().Add(-3 * time.Hour),
+	}
+	var checked, deleted []string
+	server := newFakeLD(t, map[string]http.HandlerFunc{
+		"/api/v2/tokens/": func(w http.ResponseWriter, r *http.Request) {
+			id := strings.TrimPrefix(r.URL.Path, "/api/v2/tokens/")
+			if r.Method == http.MethodDelete {
+				if id == "stuck" {
+					writeJSON(w, http.StatusBadRequest, nil)
+					return
+				}
+				deleted = append(deleted, id)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			checked = append(checked, id)
+			writeJSON(w, http.StatusOK, ldapi.Token{Id: id, LastUsed: lastUsed[id].UnixNano() / int64(time.Millisecond)})
+		},
+	})
+
+	e, err := newTestAccEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := e.Backend.(*backend)
+	e.mustRequest(t, logical.UpdateOperation, "config", map[string]interface{}{
+		"access_token": "api-1234",
+		"base_uri":     server.URL,
+		"skip_verify":  true,
+	})
+
+	for id, issuedAt := range map[string]time.Time{
+		"idle":   time.Now().Add(-4 * time.Hour),
+		"active": time.Now().Add(-4 * time.Hour),
+		"recent": time.Now().Add(-30 * time.Minute),
+		"stuck":  time.Now().Add(-4 * time.Hour),
+	} {
+		if err := b.putIssued(e.Context, e.Storage, &issuedEntry{
+			ID:             id,
+			CredentialType: "api",
+			Connection:     defaultConnection,
+			IssuedAt:       issuedAt,
+			IdleTimeout:    time.Hour,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := b.revokeIdleCredentials(e.Context, e.Storage); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(checked)
+	if strings.Join(checked, ",") != "active,idle,stuck" {
+		t.Fatalf("expected only the tokens past their timeout to be checked, got %v", checked)
+	}
+	if len(deleted) != 1 || deleted[0] != "idle" {
+		t.Fatalf("expected the idle token to be deleted, got %v", deleted)
+	}
+	if entry, _ := b.issued(e.Context, e.Storage, "idle"); entry != nil {
+		t.Fatal("expected the record of the idle token to be removed")
+	}
+	if entry, err := b.revokeQueueEntry(e.Context, e.Storage, "stuck"); err != nil || entry == nil {
+		t.Fatalf("expected the failed deletion to be queued, got %#v, %v", entry, err)
+	}
+
+	// The last use of the active token is remembered and the stuck token is
+	// left to the revocation queue, so neither is checked again.
+	checked = nil
+	if err := b.revokeIdleCredentials(e.Context, e.Storage); err != nil {
+		t.Fatal(err)
+	}
+	if len(checked) != 0 {
+		t.Fatalf("expected no token to be checked, got %v", checked)
+	}
+}
+
+func TestIssuedRotate(t *testing.T) {
+	var expiry string
+	server := newFakeLD(t, map[string]http.HandlerFunc{
+		"/api/v2/tokens/issued/reset": func(w http.ResponseWriter, r *http.Request) {
+			expiry = r.URL.Query().Get("expiry")
+			writeJSON(w, http.StatusOK, ldapi.Token{Id: "issued", Token: "api-rotated"})
+		},
+		"/api/v2/account/relay-auto-configs/relay/reset": func(w http.ResponseWriter, r *http.Request) {
+			expiry = r.URL.Query().Get("expiry")
+			writeJSON(w, http.StatusOK, ldapi.RelayProxyConfig{Id: "relay", FullKey: "rel-rotated"})
+		},
+	})
+
+	e, err := newTestAccEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := e.Backend.(*backend)
+	e.mustRequest(t, logical.UpdateOperation, "config", map[string]interface{}{
+		"access_token": "api-1234",
+		"base_uri":     server.URL,
+		"skip_verify":  true,
+	})
+	for _, entry := range []*issuedEntry{
+		{ID: "issued", CredentialType: "api", SecretType: "role", Role: roleTestName},
+		{ID: "relay", CredentialType: "rac", SecretType: "relay", Policy: "relay"},
+	} {
+		entry.Connection = defaultConnection
+		if err := b.putIssued(e.Context, e.Storage, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp := e.mustRequest(t, logical.UpdateOperation, "issued/issued/rotate", nil)
+	if resp.Data["token"] != "api-rotated" || resp.Data["api_key_id"] != "issued" {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+	if expiry != "" {
+		t.Fatalf("expected the old value to expire at once, got expiry %q", expiry)
+	}
+	if entry, _ := b.issued(e.Context, e.Storage, "issued"); entry == nil || entry.RotatedAt.IsZero() {
+		t.Fatalf("expected the rotation to be recorded, got %#v", entry)
+	}
+
+	resp = e.mustRequest(t, logical.UpdateOperation, "issued/relay/rotate", map[string]interface{}{"old_value_ttl": "1h"})
+	if resp.Data["token"] != "rel-rotated" {
+		t.Fatalf("unexpected response: %#v", resp.Data)
+	}
+	millis, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		t.Fatalf("expected an expiry for the old value, got %q", expiry)
+	}
+	if d := time.Until(millisToTime(millis)); d < 59*time.Minute || d > time.Hour {
+		t.Fatalf("expected the old value to expire in 1h, got %v", d)
+	}
+}
@@ -0,0 +1,60 @@
+package launchdarkly
+
+import (
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// leaseLimits are the lease settings that apply to a secret: those of its
+// role, falling back to those of the connection.
+type leaseLimits struct {
+	TTL       time.Duration
+	MaxTTL    time.Duration
+	Period    time.Duration
+	Renewable bool
+}
+
+// secretLimits returns the lease settings of a secret issued through config,
+// for role if it was issued for one.
+func secretLimits(config *launchdarklyConfig, role *launchdarklyRoleEntry) leaseLimits {
+	// The connection stores its TTLs as a number of seconds.
+	limits := leaseLimits{
+		TTL:       config.TTL * time.Second,
+		MaxTTL:    config.MaxTTL * time.Second,
+		Renewable: true,
+	}
+	if role == nil {
+		return limits
+	}
+
+	if role.TTL != 0 {
+		limits.TTL = role.TTL
+	}
+	if role.MaxTTL != 0 {
+		limits.MaxTTL = role.MaxTTL
+	}
+	limits.Period = role.Period
+	limits.Renewable = !role.NonRenewable
+	return limits
+}
+
+// applyLease sets the lease of a new secret. requested is the ttl asked for by
+// the caller, capped by the max TTL of the role, mount and system.
+func (b *backend) applyLease(resp *logical.Response, limits leaseLimits, requested time.Duration) error {
+	ttl, warnings, err := framework.CalculateTTL(b.System(), requested, limits.TTL, limits.Period, limits.MaxTTL, 0, time.Time{})
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		resp.AddWarning(warning)
+	}
+
+	resp.Secret.TTL = ttl
+	resp.Secret.Renewable = limits.Renewable
+	if limits.Period == 0 {
+		resp.Secret.MaxTTL = limits.MaxTTL
+	}
+	return nil
+}
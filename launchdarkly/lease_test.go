@@ -0,0 +1,99 @@
+package launchdarkly
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	ldapi "github.com/launchdarkly/api-client-go"
+)
+
+func TestLease(t *testing.T) {
+	server := newFakeLD(t, map[string]http.HandlerFunc{
+		"/api/v2/tokens": func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusCreated, ldapi.Token{Id: "issued", Token: "api-issued"})
+		},
+	})
+
+	e, err := newTestAccEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRole := func(name string, data map[string]interface{}) {
+		t.Helper()
+		data["custom_role_ids"] = []string{"reader"}
+		e.mustRequest(t, logical.UpdateOperation, "role/"+name, data)
+	}
+	creds := func(name string, data map[string]interface{}) *logical.Secret {
+		t.Helper()
+		return e.mustRequest(t, logical.ReadOperation, "creds/"+name, data).Secret
+	}
+	renew := func(secret *logical.Secret, issued time.Duration) (*logical.Response, error) {
+		secret.IssueTime = time.Now().Add(-issued)
+		return e.handle(&logical.Request{Operation: logical.RenewOperation, Secret: secret})
+	}
+
+	e.mustRequest(t, logical.UpdateOperation, "config", map[string]interface{}{
+		"access_token": "api-1234",
+		"base_uri":     server.URL,
+		"skip_verify":  true,
+	})
+
+	t.Run("role limits", func(t *testing.T) {
+		writeRole("limited", map[string]interface{}{"ttl": "10m", "max_ttl": "30m"})
+
+		if secret := creds("limited", nil); secret.TTL != 10*time.Minute || secret.MaxTTL != 30*time.Minute {
+			t.Fatalf("unexpected lease: ttl %v, max_ttl %v", secret.TTL, secret.MaxTTL)
+		}
+		if secret := creds("limited", map[string]interface{}{"ttl": "20m"}); secret.TTL != 20*time.Minute {
+			t.Fatalf("expected the requested ttl, got %v", secret.TTL)
+		}
+		secret := creds("limited", map[string]interface{}{"ttl": "2h"})
+		if secret.TTL != 30*time.Minute {
+			t.Fatalf("expected the requested ttl to be capped by the role, got %v", secret.TTL)
+		}
+
+		resp, err := renew(secret, 25*time.Minute)
+		if err != nil || resp.IsError() {
+			t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+		}
+		if ttl := resp.Secret.TTL; ttl < 4*time.Minute || ttl > 5*time.Minute {
+			t.Fatalf("expected the renewal to stop at max_ttl, got %v", ttl)
+		}
+	})
+
+	t.Run("periodic", func(t *testing.T) {
+		writeRole("periodic", map[string]interface{}{"period": "15m", "max_ttl": "30m"})
+
+		secret := creds("periodic", nil)
+		if secret.TTL != 15*time.Minute || secret.MaxTTL != 0 {
+			t.Fatalf("unexpected lease: ttl %v, max_ttl %v", secret.TTL, secret.MaxTTL)
+		}
+		resp, err := renew(secret, 2*time.Hour)
+		if err != nil || resp.IsError() {
+			t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+		}
+		if resp.Secret.TTL != 15*time.Minute {
+			t.Fatalf("expected the period, got %v", resp.Secret.TTL)
+		}
+	})
+
+	t.Run("not renewable", func(t *testing.T) {
+		writeRole("fixed", map[string]interface{}{"renewable": false})
+
+		secret := creds("fixed", nil)
+		if secret.Renewable {
+			t.Fatal("expected the lease not to be renewable")
+		}
+		if resp, err := renew(secret, time.Minute); err == nil && !resp.IsError() {
+			t.Fatal("expected the renewal to fail")
+		}
+
+		e.mustRefuse(t, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/fixed",
+			Data:      map[string]interface{}{"period": "1h"},
+		})
+	})
+}
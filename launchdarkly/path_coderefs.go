@@ -4,7 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"time"
+	"net/http"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/sdk/framework"
@@ -19,32 +19,72 @@ func (b *backend) pathCoderefsRead(ctx context.Context, req *logical.Request, da
 	if projectName == "" {
 		return nil, errors.New("project is required")
 	}
-	config, err := getConfig(b, ctx, req.Storage)
+	connection := connectionName(data)
+	client, err := b.client(ctx, req.Storage, connection)
 	if err != nil {
 		return nil, err
 	}
+	config := client.config
 
-	token, err := CreateCodeRefsToken(config, projectName)
+	names := newNameTemplateData(req, projectName)
+	names.Project = projectName
+	tokenName, description, err := credentialNames(config, nil, names)
 	if err != nil {
 		return nil, err
 	}
 
+	walID, wal, err := putWAL(ctx, req.Storage, walTokenKind, connection, client.ownedName(tokenName))
+	if err != nil {
+		return nil, err
+	}
+	token, err := CreateCodeRefsToken(ctx, client, projectName, tokenName)
+	if err != nil {
+		b.createFailed(ctx, req.Storage, walID, err)
+		return nil, err
+	}
+	walID = b.recordCreated(ctx, req.Storage, walID, walTokenKind, wal, token.Id)
+
 	resp := b.Secret(programmaticAPIKey).Response(map[string]interface{}{
 		"token": token.Token,
 	}, map[string]interface{}{
 		"api_key_id":      token.Id,
 		"credential_type": "api",
 		"secret_type":     "coderefs",
+		"connection":      connection,
 	})
 
-	resp.Secret.MaxTTL = config.MaxTTL * time.Second
-	resp.Secret.TTL = config.TTL * time.Second
+	if description != "" {
+		if err := SetTokenDescription(ctx, client, token.Id, description); err != nil {
+			resp.AddWarning("could not set the description of the token: " + err.Error())
+		}
+	}
+
+	if err := b.applyLease(resp, secretLimits(config, nil), 0); err != nil {
+		return nil, err
+	}
 
+	if err := b.putIssued(ctx, req.Storage, &issuedEntry{
+		ID:             token.Id,
+		CredentialType: "api",
+		SecretType:     "coderefs",
+		Project:        projectName,
+		Connection:     connection,
+		Name:           token.Name,
+		EntityID:       req.EntityID,
+		DisplayName:    req.DisplayName,
+		ExpiresAt:      b.leaseExpiry(resp.Secret.TTL),
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := framework.DeleteWAL(ctx, req.Storage, walID); err != nil {
+		return nil, err
+	}
 	return resp, nil
 }
 
 // CreateCodeRefsToken uses launchdarkly API to create an API token
-func CreateCodeRefsToken(config *launchdarklyConfig, project string) (*ldapi.Token, error) {
+func CreateCodeRefsToken(ctx context.Context, client *Client, project string, name string) (*ldapi.Token, error) {
 	//logger := hclog.New(&hclog.LoggerOptions{})
 
 	// Prepare request
@@ -57,21 +97,19 @@ func CreateCodeRefsToken(config *launchdarklyConfig, project string) (*ldapi.Tok
 	}
 
 	newToken := ldapi.TokenBody{
-		Name:              project,
+		Name:              client.ownedName(name),
 		InlineRole:        []ldapi.Statement{statement},
 		ServiceToken:      true,
 		DefaultApiVersion: 20191212,
 	}
 
-	client, err := newClient(config, false)
-	if err != nil {
-		return nil, err
-	}
-
-	token, _, err := client.ld.AccessTokensApi.PostToken(client.ctx, newToken)
+	tokenRaw, res, err := handleRateLimit(ctx, false, false, func() (interface{}, *http.Response, error) {
+		return client.ld.AccessTokensApi.PostToken(client.authContext(ctx), newToken)
+	})
 	if err != nil {
-		return nil, handleLdapiErr(err)
+		return nil, createErr(res, err)
 	}
+	token := tokenRaw.(ldapi.Token)
 
 	return &token, nil
 
@@ -2,20 +2,73 @@ package launchdarkly
 
 import (
 	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
+	ldapi "github.com/launchdarkly/api-client-go"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
 )
 
+// defaultConnection is the name of the connection stored at config.
+const defaultConnection = "default"
+
+// reservedConnections cannot name a connection, since their config/<name>
+// path belongs to another endpoint.
+var reservedConnections = map[string]bool{
+	"rotate-root": true,
+}
+
+const (
+	authTypeAPIKey = "api_key"
+	authTypeOAuth  = "oauth"
+)
+
+// instances maps the LaunchDarkly instance presets to their base URI.
+var instances = map[string]string{
+	"commercial": "https://app.launchdarkly.com",
+	"federal":    "https://app.launchdarkly.us",
+	"eu":         "https://app.eu.launchdarkly.com",
+}
+
 type launchdarklyConfig struct {
-	AccessToken string `json:"access_token"`
-	BaseUri     string `json:"base_uri"`
-	TTL         time.Duration
-	MaxTTL      time.Duration
+	AccessToken    string `json:"access_token"`
+	AccessTokenID  string `json:"access_token_id"`
+	BaseUri        string `json:"base_uri"`
+	TTL            time.Duration
+	MaxTTL         time.Duration
+	RotationPeriod time.Duration `json:"rotation_period"`
+	LastRotated    time.Time     `json:"last_rotated"`
+
+	AuthType          string   `json:"auth_type"`
+	OAuthClientID     string   `json:"oauth_client_id"`
+	OAuthClientSecret string   `json:"oauth_client_secret"`
+	OAuthTokenURL     string   `json:"oauth_token_url"`
+	OAuthScopes       []string `json:"oauth_scopes"`
+
+	Instance       string            `json:"instance"`
+	CACert         string            `json:"ca_cert"`
+	ProxyURL       string            `json:"proxy_url"`
+	RequestTimeout time.Duration     `json:"request_timeout"`
+	Headers        map[string]string `json:"headers"`
+	RateLimit      int               `json:"rate_limit"`
+	RateLimitBurst int               `json:"rate_limit_burst"`
+
+	OwnerPrefix       string        `json:"owner_prefix"`
+	ReconcileInterval time.Duration `json:"reconcile_interval"`
+
+	NameTemplate        string `json:"name_template"`
+	DescriptionTemplate string `json:"description_template"`
 }
 
 func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -24,12 +77,16 @@ func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, dat
 		return nil, logical.CodedError(422, err.Error())
 	}
 
-	accessToken := data.Get("access_token").(string)
-	if accessToken != "" && !strings.HasPrefix(accessToken, "api-") {
-		return nil, errors.New("Token should start with `api-`")
+	connection := connectionName(data)
+	if reservedConnections[connection] {
+		return logical.ErrorResponse(fmt.Sprintf("%q is reserved and cannot be used as a connection name", connection)), nil
 	}
 
-	config, err := b.config(ctx, req.Storage)
+	lock := locksutil.LockForKey(b.configLocks, connection)
+	lock.Lock()
+	defer lock.Unlock()
+
+	config, err := b.config(ctx, req.Storage, connection)
 
 	if err != nil {
 		return nil, err
@@ -40,14 +97,32 @@ func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, dat
 			AccessToken: "",
 			MaxTTL:      0,
 			TTL:         0,
+			AuthType:    authTypeAPIKey,
 		}
 	}
 
+	previous := *config
 	if err := config.Update(data); err != nil {
 		return logical.ErrorResponse(fmt.Sprintf("could not update config: %v", err)), nil
 	}
 
-	entry, err := logical.StorageEntryJSON("config", config)
+	if config.authType() == authTypeAPIKey && config.AccessToken != "" && !strings.HasPrefix(config.AccessToken, "api-") {
+		return nil, errors.New("Token should start with `api-`")
+	}
+
+	changed := config.AccessToken != previous.AccessToken || config.BaseUri != previous.BaseUri ||
+		config.CACert != previous.CACert || config.ProxyURL != previous.ProxyURL ||
+		config.RequestTimeout != previous.RequestTimeout || !reflect.DeepEqual(config.Headers, previous.Headers) ||
+		config.AuthType != previous.AuthType || config.OAuthClientID != previous.OAuthClientID ||
+		config.OAuthClientSecret != previous.OAuthClientSecret || config.OAuthTokenURL != previous.OAuthTokenURL ||
+		!reflect.DeepEqual(config.OAuthScopes, previous.OAuthScopes)
+	if changed && configCheck(config) == nil && !data.Get("skip_verify").(bool) {
+		if err := b.verifyConfig(ctx, connection, config); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("could not verify the access token against %s: %v", config.BaseUri, err)), nil
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON(configStorageKey(connection), config)
 	if err != nil {
 		return nil, err
 	}
@@ -56,14 +131,14 @@ func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, dat
 		return nil, err
 	}
 
-	// Invalidate existing clients so they read the new configuration
-	b.Close()
+	// Invalidate the existing client so it reads the new configuration
+	b.resetClient(connection)
 
 	return nil, nil
 }
 
 func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	config, err := b.config(ctx, req.Storage)
+	config, err := b.config(ctx, req.Storage, connectionName(data))
 	if err != nil {
 		return nil, err
 	}
@@ -72,15 +147,104 @@ func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, data
 	}
 
 	resp := make(map[string]interface{})
+	var warnings []string
+
+	resp["auth_type"] = config.authType()
+
+	if v := config.OAuthClientID; v != "" {
+		resp["oauth_client_id"] = v
+		resp["oauth_token_url"] = config.oauthTokenURL()
+		resp["oauth_scopes"] = config.OAuthScopes
+	}
 
 	if v := config.AccessToken; v != "" {
-		resp["access_token"] = v
+		resp["access_token_masked"] = maskAccessToken(v)
+	}
+
+	if config.authType() == authTypeAPIKey && config.AccessToken != "" {
+		if client, err := b.client(ctx, req.Storage, connectionName(data)); err != nil {
+			warnings = append(warnings, fmt.Sprintf("could not read the access token metadata from LaunchDarkly: %v", err))
+		} else if token, err := accessTokenMetadata(ctx, client, config); err != nil {
+			warnings = append(warnings, fmt.Sprintf("could not read the access token metadata from LaunchDarkly: %v", err))
+		} else {
+			resp["access_token_name"] = token.Name
+			resp["custom_role_ids"] = token.CustomRoleIds
+			if token.Role != "" {
+				resp["role"] = token.Role
+			}
+			if token.LastUsed != 0 {
+				resp["last_used"] = time.Unix(0, token.LastUsed*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+			}
+			config.AccessTokenID = token.Id
+		}
+	}
+
+	if v := config.AccessTokenID; v != "" {
+		resp["access_token_id"] = v
 	}
 
 	if v := config.BaseUri; v != "" {
 		resp["base_uri"] = v
 	}
 
+	if v := config.Instance; v != "" {
+		resp["instance"] = v
+	}
+
+	if v := config.CACert; v != "" {
+		resp["ca_cert"] = v
+	}
+
+	if v := config.ProxyURL; v != "" {
+		resp["proxy_url"] = v
+	}
+
+	if v := config.RequestTimeout; v != 0 {
+		resp["request_timeout"] = int64(v.Seconds())
+	}
+
+	// Header values may carry credentials, so only their names are returned.
+	if len(config.Headers) > 0 {
+		names := make([]string, 0, len(config.Headers))
+		for name := range config.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		resp["headers"] = names
+	}
+
+	if v := config.RateLimit; v != 0 {
+		resp["rate_limit"] = v
+	}
+
+	if v := config.RateLimitBurst; v != 0 {
+		resp["rate_limit_burst"] = v
+	}
+
+	if v := config.OwnerPrefix; v != "" {
+		resp["owner_prefix"] = v
+	}
+
+	if v := config.ReconcileInterval; v != 0 {
+		resp["reconcile_interval"] = int64(v.Seconds())
+	}
+
+	if v := config.NameTemplate; v != "" {
+		resp["name_template"] = v
+	}
+
+	if v := config.DescriptionTemplate; v != "" {
+		resp["description_template"] = v
+	}
+
+	if v := config.RotationPeriod; v != 0 {
+		resp["rotation_period"] = int64(v.Seconds())
+	}
+
+	if v := config.LastRotated; !v.IsZero() {
+		resp["last_rotated"] = v.Format(time.RFC3339)
+	}
+
 	if v := config.MaxTTL; v != 0 {
 		resp["max_ttl"] = v
 	}
@@ -89,19 +253,177 @@ func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, data
 		resp["ttl"] = v
 	}
 	return &logical.Response{
-		Data: resp,
+		Data:     resp,
+		Warnings: warnings,
 	}, nil
 }
 
+func (b *backend) pathConfigDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	connection := connectionName(data)
+	if err := req.Storage.Delete(ctx, configStorageKey(connection)); err != nil {
+		return nil, err
+	}
+
+	b.resetClient(connection)
+
+	return nil, nil
+}
+
+func (b *backend) pathConfigList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	connections, err := b.connections(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(connections))
+	for _, connection := range connections {
+		if !strings.HasSuffix(connection, "/") {
+			names = append(names, connection)
+		}
+	}
+
+	return listKeys(names, data)
+}
+
 func (config *launchdarklyConfig) Update(data *framework.FieldData) error {
 	accessToken := data.Get("access_token").(string)
-	if len(accessToken) > 0 {
+	if len(accessToken) > 0 && accessToken != config.AccessToken {
 		config.AccessToken = accessToken
+		// The id belongs to the previous token, it is looked up again on rotation.
+		config.AccessTokenID = ""
+		config.LastRotated = time.Now()
+	}
+
+	if accessTokenID, ok := data.GetOk("access_token_id"); ok {
+		config.AccessTokenID = accessTokenID.(string)
 	}
 
+	if authType, ok := data.GetOk("auth_type"); ok {
+		switch authType.(string) {
+		case authTypeAPIKey, authTypeOAuth:
+			config.AuthType = authType.(string)
+		default:
+			return fmt.Errorf("auth_type must be %s or %s", authTypeAPIKey, authTypeOAuth)
+		}
+		// Switching back to an API key drops the client credentials.
+		if config.AuthType == authTypeAPIKey {
+			config.OAuthClientID = ""
+			config.OAuthClientSecret = ""
+			config.OAuthTokenURL = ""
+			config.OAuthScopes = nil
+		}
+	}
+	if v, ok := data.GetOk("oauth_client_id"); ok {
+		config.OAuthClientID = v.(string)
+	}
+	if v, ok := data.GetOk("oauth_client_secret"); ok {
+		config.OAuthClientSecret = v.(string)
+	}
+	if v, ok := data.GetOk("oauth_token_url"); ok {
+		config.OAuthTokenURL = v.(string)
+	}
+	if v, ok := data.GetOk("oauth_scopes"); ok {
+		config.OAuthScopes = v.([]string)
+	}
+	if config.authType() == authTypeAPIKey && config.OAuthClientID != "" {
+		return errors.New("oauth_client_id requires auth_type=oauth")
+	}
+
+	instance := data.Get("instance").(string)
 	baseUri := data.Get("base_uri").(string)
+	if len(instance) > 0 && len(baseUri) > 0 {
+		return errors.New("instance and base_uri are mutually exclusive")
+	}
+	if len(instance) > 0 {
+		if _, ok := instances[instance]; !ok {
+			return fmt.Errorf("unknown instance %q, must be one of commercial, federal or eu", instance)
+		}
+		config.Instance = instance
+		config.BaseUri = instances[instance]
+	}
 	if len(baseUri) > 0 {
-		config.BaseUri = baseUri
+		u, err := url.Parse(baseUri)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("base_uri must be an absolute http(s) URL, got %q", baseUri)
+		}
+		config.Instance = ""
+		config.BaseUri = strings.TrimSuffix(baseUri, "/")
+	}
+
+	if v, ok := data.GetOk("ca_cert"); ok {
+		config.CACert = v.(string)
+		if config.CACert != "" && !x509.NewCertPool().AppendCertsFromPEM([]byte(config.CACert)) {
+			return errors.New("ca_cert does not contain a valid PEM certificate")
+		}
+	}
+	if v, ok := data.GetOk("proxy_url"); ok {
+		config.ProxyURL = v.(string)
+		if config.ProxyURL != "" {
+			if u, err := url.Parse(config.ProxyURL); err != nil || u.Host == "" {
+				return fmt.Errorf("invalid proxy_url %q", config.ProxyURL)
+			}
+		}
+	}
+	if v, ok := data.GetOk("request_timeout"); ok {
+		if v.(int) < 0 {
+			return errors.New("request_timeout cannot be negative")
+		}
+		config.RequestTimeout = time.Duration(v.(int)) * time.Second
+	}
+	if v, ok := data.GetOk("headers"); ok {
+		headers := v.(map[string]string)
+		for k := range headers {
+			if strings.EqualFold(k, "Authorization") {
+				return errors.New("the Authorization header cannot be overridden")
+			}
+		}
+		config.Headers = headers
+	}
+
+	if v, ok := data.GetOk("rate_limit"); ok {
+		if v.(int) < 0 {
+			return errors.New("rate_limit cannot be negative")
+		}
+		config.RateLimit = v.(int)
+	}
+	if v, ok := data.GetOk("rate_limit_burst"); ok {
+		if v.(int) < 0 {
+			return errors.New("rate_limit_burst cannot be negative")
+		}
+		config.RateLimitBurst = v.(int)
+	}
+
+	if v, ok := data.GetOk("owner_prefix"); ok {
+		config.OwnerPrefix = v.(string)
+	}
+	if v, ok := data.GetOk("reconcile_interval"); ok {
+		if v.(int) < 0 {
+			return errors.New("reconcile_interval cannot be negative")
+		}
+		config.ReconcileInterval = time.Duration(v.(int)) * time.Second
+	}
+	if config.ReconcileInterval > 0 && config.OwnerPrefix == "" {
+		return errors.New("reconcile_interval requires owner_prefix")
+	}
+
+	if v, ok := data.GetOk("name_template"); ok {
+		if err := validateTemplate("name_template", v.(string)); err != nil {
+			return err
+		}
+		config.NameTemplate = v.(string)
+	}
+	if v, ok := data.GetOk("description_template"); ok {
+		if err := validateTemplate("description_template", v.(string)); err != nil {
+			return err
+		}
+		config.DescriptionTemplate = v.(string)
+	}
+
+	if rotationPeriod, ok := data.GetOk("rotation_period"); ok {
+		if rotationPeriod.(int) < 0 {
+			return errors.New("rotation_period cannot be negative")
+		}
+		config.RotationPeriod = time.Duration(rotationPeriod.(int)) * time.Second
 	}
 
 	maxTTL, ok := data.GetOk("max_ttl")
@@ -125,9 +447,277 @@ func (config *launchdarklyConfig) Update(data *framework.FieldData) error {
 	return nil
 }
 
-func (b *backend) config(ctx context.Context, s logical.Storage) (*launchdarklyConfig, error) {
-	config := &launchdarklyConfig{}
+// authType returns how the connection authenticates. Connections written
+// before auth_type existed use API keys.
+func (config *launchdarklyConfig) authType() string {
+	if config.AuthType == "" {
+		return authTypeAPIKey
+	}
+	return config.AuthType
+}
+
+func (config *launchdarklyConfig) oauthTokenURL() string {
+	if config.OAuthTokenURL != "" {
+		return config.OAuthTokenURL
+	}
+	return config.BaseUri + "/trust/oauth/token"
+}
+
+// oauthTokenSource fetches OAuth access tokens with the client credentials of
+// the connection, refreshing them as they expire.
+func (config *launchdarklyConfig) oauthTokenSource(httpClient *http.Client) oauth2.TokenSource {
+	cc := &clientcredentials.Config{
+		ClientID:     config.OAuthClientID,
+		ClientSecret: config.OAuthClientSecret,
+		TokenURL:     config.oauthTokenURL(),
+		Scopes:       config.OAuthScopes,
+	}
+	return cc.TokenSource(context.WithValue(context.Background(), oauth2.HTTPClient, httpClient))
+}
+
+// verifyConfig makes sure the credentials and base URI can be used to call
+// LaunchDarkly, and records the id of the access token.
+func (b *backend) verifyConfig(ctx context.Context, connection string, config *launchdarklyConfig) error {
+	client, err := b.newConnectionClient(connection, config)
+	if err != nil {
+		return err
+	}
+	defer client.close()
+
+	// OAuth tokens are not access tokens, so there is no token record to read.
+	if config.authType() == authTypeOAuth {
+		_, _, err := handleRateLimit(ctx, true, true, func() (interface{}, *http.Response, error) {
+			return client.ld.AccessTokensApi.GetTokens(client.authContext(ctx), nil)
+		})
+		return handleLdapiErr(err)
+	}
+
+	token, err := accessTokenMetadata(ctx, client, config)
+	if err != nil {
+		return err
+	}
+	config.AccessTokenID = token.Id
+
+	return nil
+}
+
+// accessTokenMetadata reads the LaunchDarkly record of the configured access
+// token. Without an access_token_id the token is matched on its last digits,
+// which are the only part of a token the API exposes, so the match has to be
+// unambiguous.
+func accessTokenMetadata(ctx context.Context, client *Client, config *launchdarklyConfig) (*ldapi.Token, error) {
+	if config.AccessTokenID != "" {
+		tokenRaw, _, err := handleRateLimit(ctx, true, true, func() (interface{}, *http.Response, error) {
+			return client.ld.AccessTokensApi.GetToken(client.authContext(ctx), config.AccessTokenID)
+		})
+		if err != nil {
+			return nil, handleLdapiErr(err)
+		}
+		token := tokenRaw.(ldapi.Token)
+		return &token, nil
+	}
+
+	tokensRaw, _, err := handleRateLimit(ctx, true, true, func() (interface{}, *http.Response, error) {
+		return client.ld.AccessTokensApi.GetTokens(client.authContext(ctx), nil)
+	})
+	if err != nil {
+		return nil, handleLdapiErr(err)
+	}
+	tokens := tokensRaw.(ldapi.Tokens)
+
+	var matches []ldapi.Token
+	for _, token := range tokens.Items {
+		if token.Token != "" && strings.HasSuffix(config.AccessToken, token.Token) {
+			matches = append(matches, token)
+		}
+	}
+	if len(matches) != 1 {
+		return nil, errors.New("could not identify the configured access token, set access_token_id in config")
+	}
+
+	return &matches[0], nil
+}
+
+// maskAccessToken hides all but the last four characters of a token.
+func maskAccessToken(token string) string {
+	if len(token) <= 4 {
+		return strings.Repeat("*", len(token))
+	}
+	return strings.Repeat("*", len(token)-4) + token[len(token)-4:]
+}
+
+// configFields is the schema shared by the default and the named connections.
+func configFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"access_token": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "LaunchDarkly access Token",
+			Default:     "",
+		},
+		"access_token_id": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "LaunchDarkly id of the access token. Looked up from the token when not set.",
+		},
+		"base_uri": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "LaunchDarkly baseUri. Use instance for the LaunchDarkly hosted instances.",
+			Default:     "",
+		},
+		"instance": {
+			Type:          framework.TypeString,
+			Description:   "LaunchDarkly instance to connect to, sets base_uri accordingly.",
+			AllowedValues: []interface{}{"commercial", "federal", "eu"},
+		},
+		"ca_cert": {
+			Type:        framework.TypeString,
+			Description: "PEM encoded CA certificate trusted in addition to the system roots.",
+		},
+		"proxy_url": {
+			Type:        framework.TypeString,
+			Description: "HTTP proxy used to reach LaunchDarkly. Defaults to the proxy environment variables.",
+		},
+		"request_timeout": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Timeout for each LaunchDarkly API request. If <= 0, defaults to 30 seconds.",
+		},
+		"headers": {
+			Type:        framework.TypeKVPairs,
+			Description: "Additional HTTP headers sent with every LaunchDarkly API request.",
+		},
+		"rate_limit": {
+			Type:        framework.TypeInt,
+			Description: "Requests per second sent to LaunchDarkly through this connection. If <= 0, defaults to 10.",
+		},
+		"rate_limit_burst": {
+			Type:        framework.TypeInt,
+			Description: "Requests that may be sent at once before rate_limit applies. If <= 0, defaults to 10.",
+		},
+		"ttl": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Default lease for generated keys. If <= 0, will use system default.",
+		},
+		"max_ttl": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Maximum time a service account key is valid for. If <= 0, will use system default.",
+		},
+		"skip_verify": {
+			Type:        framework.TypeBool,
+			Description: "Store the access token without checking it against LaunchDarkly.",
+		},
+		"auth_type": {
+			Type:          framework.TypeString,
+			Description:   "How to authenticate to LaunchDarkly, api_key or oauth.",
+			AllowedValues: []interface{}{authTypeAPIKey, authTypeOAuth},
+		},
+		"oauth_client_id": {
+			Type:        framework.TypeString,
+			Description: "OAuth client id. When set with auth_type=oauth, access tokens are fetched and refreshed with the client credentials.",
+		},
+		"oauth_client_secret": {
+			Type:        framework.TypeString,
+			Description: "OAuth client secret.",
+		},
+		"oauth_token_url": {
+			Type:        framework.TypeString,
+			Description: "OAuth token endpoint. Defaults to <base_uri>/trust/oauth/token.",
+		},
+		"oauth_scopes": {
+			Type:        framework.TypeCommaStringSlice,
+			Description: "OAuth scopes to request with the client credentials.",
+		},
+		"owner_prefix": {
+			Type:        framework.TypeString,
+			Description: "Prefix added to the names of the tokens and relay proxy configs created through this connection, used by reconcile to recognize them.",
+		},
+		"reconcile_interval": {
+			Type:        framework.TypeDurationSecond,
+			Description: "How often orphaned credentials are cleaned up automatically. If <= 0, only through the reconcile endpoint. Requires owner_prefix.",
+		},
+		"rotation_period": {
+			Type:        framework.TypeDurationSecond,
+			Description: "How often the access token is rotated automatically. If <= 0, the token is only rotated through rotate-root.",
+		},
+		"name_template": {
+			Type:        framework.TypeString,
+			Description: "Go template for the names of the credentials created through this connection, unless their role sets one.",
+		},
+		"description_template": {
+			Type:        framework.TypeString,
+			Description: "Go template for the descriptions of the tokens created through this connection, unless their role sets one.",
+		},
+	}
+}
+
+// connectionFields adds the connection name to the config schema.
+func connectionFields() map[string]*framework.FieldSchema {
+	fields := configFields()
+	fields["connection"] = &framework.FieldSchema{
+		Type:        framework.TypeLowerCaseString,
+		Description: "The name of the connection.",
+	}
+	return fields
+}
+
+// connectionName returns the connection a request refers to. Paths without a
+// connection field, or requests that leave it empty, use the default connection.
+func connectionName(data *framework.FieldData) string {
+	if _, ok := data.Schema["connection"]; !ok {
+		return defaultConnection
+	}
+	if connection := data.Get("connection").(string); connection != "" {
+		return connection
+	}
+	return defaultConnection
+}
+
+// configStorageKey returns where a connection is stored. The default connection
+// lives at config so that mounts configured before named connections existed
+// keep working.
+func configStorageKey(connection string) string {
+	if connection == "" || connection == defaultConnection {
+		return "config"
+	}
+	return "config/" + connection
+}
+
+// connections returns the names of all configured connections.
+func (b *backend) connections(ctx context.Context, s logical.Storage) ([]string, error) {
+	connections, err := s.List(ctx, "config/")
+	if err != nil {
+		return nil, err
+	}
+
 	entry, err := s.Get(ctx, "config")
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		connections = append(connections, defaultConnection)
+	}
+
+	return connections, nil
+}
+
+// checkConnection returns an error response if a role or relay policy names a
+// connection that is not configured. The default connection is accepted
+// either way, so that roles can be written before the mount is configured.
+func (b *backend) checkConnection(ctx context.Context, s logical.Storage, connection string) (*logical.Response, error) {
+	if connection == "" || connection == defaultConnection {
+		return nil, nil
+	}
+	config, err := b.config(ctx, s, connection)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return logical.ErrorResponse(fmt.Sprintf("connection %q is not configured, see config/", connection)), nil
+	}
+	return nil, nil
+}
+
+func (b *backend) config(ctx context.Context, s logical.Storage, connection string) (*launchdarklyConfig, error) {
+	config := &launchdarklyConfig{}
+	entry, err := s.Get(ctx, configStorageKey(connection))
 
 	if err != nil {
 		return nil, err
@@ -0,0 +1,122 @@
+package launchdarkly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	ldapi "github.com/launchdarkly/api-client-go"
+)
+
+func (b *backend) pathConfigRotateRoot(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	connection := connectionName(data)
+	config, err := b.rotateRoot(ctx, req.Storage, connection)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"connection":      connection,
+			"access_token_id": config.AccessTokenID,
+			"last_rotated":    config.LastRotated.Format(time.RFC3339),
+		},
+	}, nil
+}
+
+// rotateRoot resets the configured access token in place, so that it keeps its
+// custom roles, and stores the new secret value. The old value stops working
+// as soon as LaunchDarkly resets the token. Only the connection being rotated
+// is locked while LaunchDarkly is called.
+func (b *backend) rotateRoot(ctx context.Context, s logical.Storage, connection string) (*launchdarklyConfig, error) {
+	lock := locksutil.LockForKey(b.configLocks, connection)
+	lock.Lock()
+	defer lock.Unlock()
+
+	config, err := getConfig(b, ctx, s, connection)
+	if err != nil {
+		return nil, err
+	}
+	if config.authType() != authTypeAPIKey {
+		return nil, errors.New("only api_key access tokens can be rotated")
+	}
+
+	client, err := b.client(ctx, s, connection)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenID := config.AccessTokenID
+	if tokenID == "" {
+		current, err := accessTokenMetadata(ctx, client, config)
+		if err != nil {
+			return nil, err
+		}
+		tokenID = current.Id
+	}
+
+	tokenRaw, _, err := handleRateLimit(ctx, true, false, func() (interface{}, *http.Response, error) {
+		return client.ld.AccessTokensApi.ResetToken(client.authContext(ctx), tokenID, nil)
+	})
+	if err != nil {
+		return nil, handleLdapiErr(err)
+	}
+	token := tokenRaw.(ldapi.Token)
+
+	config.AccessToken = token.Token
+	config.AccessTokenID = token.Id
+	config.LastRotated = time.Now()
+
+	entry, err := logical.StorageEntryJSON(configStorageKey(connection), config)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		return nil, errors.New("the access token was reset but the new value could not be stored, write a new access_token to config: " + err.Error())
+	}
+
+	// The cached client still holds the previous access token.
+	b.resetClient(connection)
+
+	return config, nil
+}
+
+// rotateRootsIfDue rotates the access token of every connection whose
+// rotation_period has elapsed.
+func (b *backend) rotateRootsIfDue(ctx context.Context, s logical.Storage) error {
+	connections, err := b.connections(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for _, connection := range connections {
+		config, err := b.config(ctx, s, connection)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if config == nil || config.AccessToken == "" || config.RotationPeriod <= 0 || config.authType() != authTypeAPIKey {
+			continue
+		}
+		if time.Since(config.LastRotated) < config.RotationPeriod {
+			continue
+		}
+
+		b.Logger().Info("rotating the configured access token", "connection", connection, "last_rotated", config.LastRotated)
+		if _, err := b.rotateRoot(ctx, s, connection); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", connection, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("could not rotate access tokens: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package launchdarkly
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	ldapi "github.com/launchdarkly/api-client-go"
+)
+
+func TestRotateRoot(t *testing.T) {
+	current := "api-1234abcd"
+	server := newFakeLD(t, map[string]http.HandlerFunc{
+		"/api/v2/tokens": func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, ldapi.Tokens{Items: []ldapi.Token{
+				{Id: "other", Token: "9999"},
+				{Id: "root", Token: "abcd"},
+			}})
+		},
+		"/api/v2/tokens/root": func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, ldapi.Token{Id: "root", Name: "vault", Token: current[len(current)-4:]})
+		},
+		"/api/v2/tokens/root/reset": func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != current {
+				writeJSON(w, http.StatusUnauthorized, nil)
+				return
+			}
+			current = "api-rotated-wxyz"
+			writeJSON(w, http.StatusOK, ldapi.Token{Id: "root", Token: current})
+		},
+	})
+
+	e, err := newTestAccEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := e.Backend.HandleRequest(e.Context, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   e.Storage,
+		Data: map[string]interface{}{
+			"access_token":    current,
+			"base_uri":        server.URL,
+			"rotation_period": "24h",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+
+	resp, err = e.Backend.HandleRequest(e.Context, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/rotate-root",
+		Storage:   e.Storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+	if resp.Data["access_token_id"] != "root" {
+		t.Fatalf("unexpected access_token_id: %#v", resp.Data["access_token_id"])
+	}
+
+	resp, err = e.Backend.HandleRequest(e.Context, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config",
+		Storage:   e.Storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data["access_token_masked"] != "************wxyz" {
+		t.Fatalf("expected the rotated token to be stored, got %#v", resp.Data["access_token_masked"])
+	}
+	if resp.Data["rotation_period"] != int64(86400) {
+		t.Fatalf("unexpected rotation_period: %#v", resp.Data["rotation_period"])
+	}
+}
+
+func TestRotateRootDoesNotBlockClients(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	server := newFakeLD(t, map[string]http.HandlerFunc{
+		"/api/v2/tokens/root/reset": func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			writeJSON(w, http.StatusOK, ldapi.Token{Id: "root", Token: "api-rotated"})
+		},
+	})
+
+	e, err := newTestAccEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := e.Backend.(*backend)
+	for _, path := range []string{"config", "config/other"} {
+		resp, err := b.HandleRequest(e.Context, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   e.Storage,
+			Data: map[string]interface{}{
+				"access_token":    "api-1234",
+				"access_token_id": "root",
+				"base_uri":        server.URL,
+				"skip_verify":     true,
+			},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+		}
+	}
+
+	rotated := make(chan error)
+	go func() {
+		_, err := b.rotateRoot(e.Context, e.Storage, defaultConnection)
+		rotated <- err
+	}()
+	<-started
+
+	clientReady := make(chan error)
+	go func() {
+		_, err := b.client(e.Context, e.Storage, "other")
+		clientReady <- err
+	}()
+	select {
+	case err := <-clientReady:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the client of another connection to be available during a rotation")
+	}
+
+	close(release)
+	if err := <-rotated; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,466 @@
+package launchdarkly
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	ldapi "github.com/launchdarkly/api-client-go"
+)
+
+func TestConfig(t *testing.T) {
+	server := newFakeLD(t, map[string]http.HandlerFunc{
+		"/api/v2/tokens": func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "api-valid-1234" {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "invalid token"})
+				return
+			}
+			writeJSON(w, http.StatusOK, ldapi.Tokens{Items: []ldapi.Token{{Id: "root", Token: "1234"}}})
+		},
+		"/api/v2/tokens/root": func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, ldapi.Token{
+				Id:            "root",
+				Name:          "vault root",
+				Token:         "1234",
+				CustomRoleIds: []string{"vault"},
+				LastUsed:      1600000000000,
+			})
+		},
+	})
+
+	e, err := newTestAccEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeConfig := func(data map[string]interface{}) (*logical.Response, error) {
+		return e.Backend.HandleRequest(e.Context, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Storage:   e.Storage,
+			Data:      data,
+		})
+	}
+
+	t.Run("rejects an invalid token", func(t *testing.T) {
+		resp, err := writeConfig(map[string]interface{}{
+			"access_token": "api-invalid",
+			"base_uri":     server.URL,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || !resp.IsError() {
+			t.Fatalf("expected an error response, got %#v", resp)
+		}
+	})
+
+	t.Run("skip_verify stores an unverified token", func(t *testing.T) {
+		resp, err := writeConfig(map[string]interface{}{
+			"access_token": "api-invalid",
+			"base_uri":     server.URL,
+			"skip_verify":  true,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+		}
+	})
+
+	t.Run("read redacts the token", func(t *testing.T) {
+		resp, err := writeConfig(map[string]interface{}{
+			"access_token": "api-valid-1234",
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+		}
+
+		resp, err = e.Backend.HandleRequest(e.Context, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "config",
+			Storage:   e.Storage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := resp.Data["access_token"]; ok {
+			t.Fatal("expected the access token to be redacted")
+		}
+		if v := resp.Data["access_token_masked"]; v != "**********1234" {
+			t.Fatalf("unexpected access_token_masked: %#v", v)
+		}
+		if v := resp.Data["access_token_id"]; v != "root" {
+			t.Fatalf("unexpected access_token_id: %#v", v)
+		}
+		if v := resp.Data["access_token_name"]; v != "vault root" {
+			t.Fatalf("unexpected access_token_name: %#v", v)
+		}
+		if v := resp.Data["last_used"]; v != "2020-09-13T12:26:40Z" {
+			t.Fatalf("unexpected last_used: %#v", v)
+		}
+	})
+}
+
+func TestConnections(t *testing.T) {
+	var posted ldapi.TokenBody
+	server := newFakeLD(t, map[string]http.HandlerFunc{
+		"/api/v2/tokens": func(w http.ResponseWriter, r *http.Request) {
+			if err := json.NewDecoder(r.Body).Decode(&posted); err != nil {
+				t.Fatal(err)
+			}
+			writeJSON(w, http.StatusCreated, ldapi.Token{Id: "sandbox-token", Token: "api-sandbox"})
+		},
+	})
+
+	e, err := newTestAccEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := []*logical.Request{
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Data: map[string]interface{}{
+				"access_token": "api-default",
+				"skip_verify":  true,
+			},
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "config/sandbox",
+			Data: map[string]interface{}{
+				"access_token": "api-sandbox-root",
+				"base_uri":     server.URL,
+				"skip_verify":  true,
+			},
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "role/sandbox-writer",
+			Data: map[string]interface{}{
+				"custom_role_ids": "writer",
+				"connection":      "sandbox",
+			},
+		},
+	}
+	for _, req := range requests {
+		req.Storage = e.Storage
+		resp, err := e.Backend.HandleRequest(e.Context, req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: %s: resp: %#v\nerr:%v", req.Path, resp, err)
+		}
+	}
+
+	for _, req := range []*logical.Request{
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "config/Rotate-Root",
+			Data:      map[string]interface{}{"access_token": "api-shadowed", "skip_verify": true},
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "role/staging-writer",
+			Data:      map[string]interface{}{"custom_role_ids": "writer", "connection": "staging"},
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      "relay/policy",
+			Data:      map[string]interface{}{"name": "staging", "inline_policy": `{"effect": "allow", "resources": ["proj/*"], "actions": ["*"]}`, "connection": "staging"},
+		},
+	} {
+		req.Storage = e.Storage
+		if resp, err := e.Backend.HandleRequest(e.Context, req); err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("expected %s to be refused, got %#v, %v", req.Path, resp, err)
+		}
+	}
+
+	resp, err := e.Backend.HandleRequest(e.Context, &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "config/",
+		Storage:   e.Storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resp.Data["keys"], []string{"default", "sandbox"}) {
+		t.Fatalf("unexpected connections: %#v", resp.Data["keys"])
+	}
+
+	for _, key := range []string{projectCacheKey(defaultConnection, "web"), projectCacheKey("sandbox", "mobile")} {
+		if err := e.Storage.Put(e.Context, &logical.StorageEntry{Key: key, Value: []byte("{}")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for connection, expected := range map[string][]string{"": {"web"}, "sandbox": {"mobile"}} {
+		resp, err := e.Backend.HandleRequest(e.Context, &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      "project/",
+			Storage:   e.Storage,
+			Data:      map[string]interface{}{"connection": connection},
+		})
+		if err != nil || !reflect.DeepEqual(resp.Data["keys"], expected) {
+			t.Fatalf("unexpected projects of connection %q: %#v, %v", connection, resp, err)
+		}
+	}
+
+	resp, err = e.Backend.HandleRequest(e.Context, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/sandbox-writer",
+		Storage:   e.Storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+	if resp.Data["token"] != "api-sandbox" {
+		t.Fatalf("unexpected token: %#v", resp.Data["token"])
+	}
+	if resp.Secret.InternalData["connection"] != "sandbox" {
+		t.Fatalf("unexpected connection: %#v", resp.Secret.InternalData["connection"])
+	}
+	if !reflect.DeepEqual(posted.CustomRoleIds, []string{"writer"}) {
+		t.Fatalf("unexpected custom roles: %#v", posted.CustomRoleIds)
+	}
+}
+
+func TestConfigOAuth(t *testing.T) {
+	tokenRequests := 0
+	server := newFakeLD(t, map[string]http.HandlerFunc{
+		"/trust/oauth/token": func(w http.ResponseWriter, r *http.Request) {
+			tokenRequests++
+			if id, secret, ok := r.BasicAuth(); !ok || id != "vault" || secret != "s3cr3t" {
+				writeJSON(w, http.StatusUnauthorized, nil)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"access_token": "oauth-token",
+				"token_type":   "bearer",
+				"expires_in":   3600,
+			})
+		},
+		"/api/v2/tokens": func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer oauth-token" {
+				writeJSON(w, http.StatusUnauthorized, nil)
+				return
+			}
+			writeJSON(w, http.StatusOK, ldapi.Tokens{})
+		},
+	})
+
+	e, err := newTestAccEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := e.Backend.HandleRequest(e.Context, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   e.Storage,
+		Data: map[string]interface{}{
+			"auth_type":           "oauth",
+			"oauth_client_id":     "vault",
+			"oauth_client_secret": "s3cr3t",
+			"base_uri":            server.URL,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+	if tokenRequests != 1 {
+		t.Fatalf("expected the client credentials to be exchanged once, got %d", tokenRequests)
+	}
+
+	resp, err = e.Backend.HandleRequest(e.Context, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config",
+		Storage:   e.Storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data["auth_type"] != "oauth" {
+		t.Fatalf("unexpected auth_type: %#v", resp.Data["auth_type"])
+	}
+	if _, ok := resp.Data["oauth_client_secret"]; ok {
+		t.Fatal("expected the client secret to be redacted")
+	}
+
+	resp, err = e.Backend.HandleRequest(e.Context, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   e.Storage,
+		Data:      map[string]interface{}{"oauth_scopes": []string{"writer"}},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+	if tokenRequests != 2 {
+		t.Fatalf("expected new scopes to be verified with a new token, got %d token requests", tokenRequests)
+	}
+
+	resp, err = e.Backend.HandleRequest(e.Context, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   e.Storage,
+		Data:      map[string]interface{}{"auth_type": "saml"},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected an unknown auth_type to be rejected, got %#v, %v", resp, err)
+	}
+
+	resp, err = e.Backend.HandleRequest(e.Context, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   e.Storage,
+		Data: map[string]interface{}{
+			"auth_type":    "api_key",
+			"access_token": "api-1234",
+			"skip_verify":  true,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("expected switching back to api_key to succeed, got %#v, %v", resp, err)
+	}
+	resp, err = e.Backend.HandleRequest(e.Context, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config",
+		Storage:   e.Storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resp.Data["oauth_client_id"]; ok || resp.Data["auth_type"] != "api_key" {
+		t.Fatalf("expected the OAuth settings to be cleared, got %#v", resp.Data)
+	}
+}
+
+func TestConfigHTTPClient(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("X-Team") != "platform":
+			writeJSON(w, http.StatusBadRequest, nil)
+		case r.URL.Path == "/api/v2/tokens":
+			writeJSON(w, http.StatusOK, ldapi.Tokens{Items: []ldapi.Token{{Id: "root", Token: "1234"}}})
+		case r.URL.Path == "/api/v2/tokens/root":
+			writeJSON(w, http.StatusOK, ldapi.Token{Id: "root", Token: "1234"})
+		default:
+			time.Sleep(2 * time.Second)
+		}
+	}))
+	defer server.Close()
+	caCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	e, err := newTestAccEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeConfig := func(data map[string]interface{}) (*logical.Response, error) {
+		return e.Backend.HandleRequest(e.Context, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Storage:   e.Storage,
+			Data:      data,
+		})
+	}
+
+	t.Run("untrusted certificate", func(t *testing.T) {
+		resp, err := writeConfig(map[string]interface{}{
+			"access_token": "api-1234",
+			"base_uri":     server.URL,
+			"headers":      []string{"X-Team=platform"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || !resp.IsError() {
+			t.Fatalf("expected an error response, got %#v", resp)
+		}
+	})
+
+	t.Run("trusted ca_cert", func(t *testing.T) {
+		resp, err := writeConfig(map[string]interface{}{
+			"access_token":    "api-1234",
+			"base_uri":        server.URL,
+			"ca_cert":         caCert,
+			"headers":         []string{"X-Team=platform"},
+			"request_timeout": 1,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+		}
+	})
+
+	t.Run("headers", func(t *testing.T) {
+		resp, err := writeConfig(map[string]interface{}{
+			"headers": []string{"X-Team=mobile"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || !resp.IsError() {
+			t.Fatalf("expected the new headers to be verified, got %#v", resp)
+		}
+
+		resp, err = e.Backend.HandleRequest(e.Context, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "config",
+			Storage:   e.Storage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(resp.Data["headers"], []string{"X-Team"}) {
+			t.Fatalf("expected only the header names, got %#v", resp.Data["headers"])
+		}
+	})
+
+	t.Run("request_timeout", func(t *testing.T) {
+		config, err := e.Backend.(*backend).config(e.Context, e.Storage, defaultConnection)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := newClient(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		if _, _, err := client.ld.AccessTokensApi.GetToken(client.authContext(e.Context), "slow"); err == nil {
+			t.Fatal("expected the request to time out")
+		}
+		if time.Since(start) > 1500*time.Millisecond {
+			t.Fatal("request_timeout was not applied")
+		}
+	})
+
+	t.Run("instance", func(t *testing.T) {
+		resp, err := writeConfig(map[string]interface{}{
+			"instance":    "federal",
+			"skip_verify": true,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+		}
+		config, err := e.Backend.(*backend).config(e.Context, e.Storage, defaultConnection)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.BaseUri != "https://app.launchdarkly.us" {
+			t.Fatalf("unexpected base_uri: %q", config.BaseUri)
+		}
+
+		resp, err = writeConfig(map[string]interface{}{
+			"instance": "staging",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || !resp.IsError() {
+			t.Fatalf("expected an error response, got %#v", resp)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package launchdarkly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) pathCredsRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+
+	role, err := b.role(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("unknown role: %s", name)), nil
+	}
+
+	connection := role.connection()
+	client, err := b.client(ctx, req.Storage, connection)
+	if err != nil {
+		return nil, err
+	}
+	config := client.config
+
+	// The policy of the issued token is scoped to the project and
+	// environment asked for, and to the identity of the requester.
+	project := data.Get("project").(string)
+	environment := data.Get("environment").(string)
+	policy, err := role.scopedPolicy(project, environment)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if policy, err = b.identityStatements(req, policy); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	scoped := *role
+	scoped.InlinePolicy = policy
+
+	names := newNameTemplateData(req, role.TokenName)
+	names.Role = name
+	names.Project = project
+	names.Environment = environment
+	tokenName, description, err := credentialNames(config, role, names)
+	if err != nil {
+		return nil, err
+	}
+
+	walID, wal, err := putWAL(ctx, req.Storage, walTokenKind, connection, client.ownedName(tokenName))
+	if err != nil {
+		return nil, err
+	}
+	token, err := CreateRoleToken(ctx, client, &scoped, tokenName)
+	if err != nil {
+		b.createFailed(ctx, req.Storage, walID, err)
+		return nil, err
+	}
+	walID = b.recordCreated(ctx, req.Storage, walID, walTokenKind, wal, token.Id)
+
+	resp := b.Secret(programmaticAPIKey).Response(map[string]interface{}{
+		"token": token.Token,
+	}, map[string]interface{}{
+		"api_key_id":      token.Id,
+		"credential_type": "api",
+		"secret_type":     "role",
+		"role":            name,
+		"connection":      connection,
+	})
+
+	if description != "" {
+		if err := SetTokenDescription(ctx, client, token.Id, description); err != nil {
+			resp.AddWarning("could not set the description of the token: " + err.Error())
+		}
+	}
+
+	requested := time.Duration(data.Get("ttl").(int)) * time.Second
+	if err := b.applyLease(resp, secretLimits(config, role), requested); err != nil {
+		return nil, err
+	}
+
+	if err := b.putIssued(ctx, req.Storage, &issuedEntry{
+		ID:             token.Id,
+		CredentialType: "api",
+		SecretType:     "role",
+		Role:           name,
+		Project:        project,
+		Environment:    environment,
+		Connection:     connection,
+		Name:           token.Name,
+		EntityID:       req.EntityID,
+		DisplayName:    req.DisplayName,
+		ExpiresAt:      b.leaseExpiry(resp.Secret.TTL),
+		IdleTimeout:    role.IdleTimeout,
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := framework.DeleteWAL(ctx, req.Storage, walID); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
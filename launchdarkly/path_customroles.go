@@ -0,0 +1,89 @@
+package launchdarkly
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	ldapi "github.com/launchdarkly/api-client-go"
+)
+
+func (b *backend) pathCustomRolesList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if err := validateFields(req, data); err != nil {
+		return nil, logical.CodedError(422, err.Error())
+	}
+
+	client, err := b.client(ctx, req.Storage, connectionName(data))
+	if err != nil {
+		return nil, err
+	}
+
+	rolesRaw, _, err := handleRateLimit(ctx, true, true, func() (interface{}, *http.Response, error) {
+		return client.ld.CustomRolesApi.GetCustomRoles(client.authContext(ctx))
+	})
+	if err != nil {
+		return nil, handleLdapiErr(err)
+	}
+
+	var keys []string
+	for _, customRole := range rolesRaw.(ldapi.CustomRoles).Items {
+		keys = append(keys, customRole.Key)
+	}
+	return listKeys(keys, data)
+}
+
+func (b *backend) pathCustomRolesRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if err := validateFields(req, data); err != nil {
+		return nil, logical.CodedError(422, err.Error())
+	}
+
+	client, err := b.client(ctx, req.Storage, connectionName(data))
+	if err != nil {
+		return nil, err
+	}
+
+	customRole, err := GetCustomRole(ctx, client, data.Get("key").(string))
+	if err != nil {
+		return nil, err
+	}
+	if customRole == nil {
+		return nil, nil
+	}
+
+	policy, err := json.Marshal(customRole.Policy)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"id":          customRole.Id,
+			"key":         customRole.Key,
+			"name":        customRole.Name,
+			"description": customRole.Description,
+			"policy":      string(policy),
+		},
+	}, nil
+}
+
+// GetCustomRole looks up a custom role by key or id. It returns nil if the
+// custom role does not exist.
+func GetCustomRole(ctx context.Context, client *Client, key string) (*ldapi.CustomRole, error) {
+	roleRaw, _, err := handleRateLimit(ctx, true, true, func() (interface{}, *http.Response, error) {
+		customRole, res, err := client.ld.CustomRolesApi.GetCustomRole(client.authContext(ctx), key)
+		if res != nil && res.StatusCode == http.StatusNotFound {
+			return nil, res, nil
+		}
+		return customRole, res, err
+	})
+	if err != nil {
+		return nil, handleLdapiErr(err)
+	}
+	if roleRaw == nil {
+		return nil, nil
+	}
+	customRole := roleRaw.(ldapi.CustomRole)
+
+	return &customRole, nil
+}
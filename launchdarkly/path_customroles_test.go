@@ -0,0 +1,76 @@
+package launchdarkly
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	ldapi "github.com/launchdarkly/api-client-go"
+)
+
+func TestCustomRoles(t *testing.T) {
+	customRoles := map[string]ldapi.CustomRole{
+		"reader": {Id: "1", Key: "reader", Name: "Reader", Policy: []ldapi.Policy{
+			{Effect: "allow", Resources: []string{"proj/*"}, Actions: []string{"viewProject"}},
+		}},
+		"writer": {Id: "2", Key: "writer", Name: "Writer"},
+		"admin":  {Id: "3", Key: "admin", Name: "Admin"},
+	}
+	server := newFakeLD(t, map[string]http.HandlerFunc{
+		"/api/v2/roles": func(w http.ResponseWriter, r *http.Request) {
+			var items []ldapi.CustomRole
+			for _, customRole := range customRoles {
+				items = append(items, customRole)
+			}
+			writeJSON(w, http.StatusOK, ldapi.CustomRoles{Items: items})
+		},
+		"/api/v2/roles/": func(w http.ResponseWriter, r *http.Request) {
+			customRole, ok := customRoles[strings.TrimPrefix(r.URL.Path, "/api/v2/roles/")]
+			if !ok {
+				writeJSON(w, http.StatusNotFound, map[string]string{"code": "not_found"})
+				return
+			}
+			writeJSON(w, http.StatusOK, customRole)
+		},
+	})
+
+	e, err := newTestAccEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a connection, custom roles cannot be checked.
+	resp := e.mustRequest(t, logical.UpdateOperation, "role/early", map[string]interface{}{"custom_role_ids": "anything"})
+	if resp == nil || len(resp.Warnings) != 1 {
+		t.Fatalf("expected a warning, got %#v", resp)
+	}
+
+	e.mustRequest(t, logical.UpdateOperation, "config", map[string]interface{}{
+		"access_token": "api-1234",
+		"base_uri":     server.URL,
+		"skip_verify":  true,
+	})
+
+	resp, err = e.request(logical.UpdateOperation, "role/unknown", map[string]interface{}{"custom_role_ids": "reader,auditor"})
+	if err != nil || resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "auditor") {
+		t.Fatalf("expected the unknown custom role to be rejected, got %#v, %v", resp, err)
+	}
+	if resp := e.mustRequest(t, logical.UpdateOperation, "role/known", map[string]interface{}{"custom_role_ids": "reader,writer"}); resp != nil {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+
+	resp = e.mustRequest(t, logical.ListOperation, "customroles/", map[string]interface{}{"after": "admin", "limit": 1})
+	if !reflect.DeepEqual(resp.Data["keys"], []string{"reader"}) {
+		t.Fatalf("unexpected keys: %#v", resp.Data["keys"])
+	}
+
+	resp = e.mustRequest(t, logical.ReadOperation, "customroles/reader", nil)
+	if resp.Data["name"] != "Reader" || resp.Data["policy"] != `[{"resources":["proj/*"],"actions":["viewProject"],"effect":"allow"}]` {
+		t.Fatalf("unexpected custom role: %#v", resp.Data)
+	}
+	if resp := e.mustRequest(t, logical.ReadOperation, "customroles/auditor", nil); resp != nil {
+		t.Fatalf("expected no custom role, got %#v", resp.Data)
+	}
+}
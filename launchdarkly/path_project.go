@@ -3,6 +3,7 @@ package launchdarkly
 import (
 	"context"
 	"errors"
+	"net/http"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -21,18 +22,14 @@ func (b *backend) pathProjectEnvRead(ctx context.Context, req *logical.Request,
 	if envKey == "" {
 		return nil, errors.New("env is required")
 	}
-	config, err := getConfig(b, ctx, req.Storage)
-	if err != nil {
-		return nil, err
-	}
-
-	client, err := newClient(config, false)
+	connection := connectionName(data)
+	client, err := b.client(ctx, req.Storage, connection)
 	if err != nil {
 		return nil, err
 	}
 
 	var envEntry map[string]interface{}
-	entry, err := req.Storage.Get(ctx, "project/"+projectKey)
+	entry, err := req.Storage.Get(ctx, projectCacheKey(connection, projectKey))
 
 	if err != nil {
 		return nil, err
@@ -49,10 +46,13 @@ func (b *backend) pathProjectEnvRead(ctx context.Context, req *logical.Request,
 		}, nil
 	}
 
-	project, _, err := client.ld.ProjectsApi.GetProject(client.ctx, projectKey)
+	projectRaw, _, err := handleRateLimit(ctx, true, true, func() (interface{}, *http.Response, error) {
+		return client.ld.ProjectsApi.GetProject(client.authContext(ctx), projectKey)
+	})
 	if err != nil {
 		return nil, handleLdapiErr(err)
 	}
+	project := projectRaw.(ldapi.Project)
 	env := []ldapi.Environment{}
 
 	// Looking for the environment that matches the path. Only 1 should match.
@@ -69,7 +69,7 @@ func (b *backend) pathProjectEnvRead(ctx context.Context, req *logical.Request,
 		"client_id": env[0].Id,
 	}
 
-	newEntry, err := logical.StorageEntryJSON("project/"+projectKey, envData)
+	newEntry, err := logical.StorageEntryJSON(projectCacheKey(connection, projectKey), envData)
 	if err != nil {
 		return nil, err
 	}
@@ -102,7 +102,7 @@ func (b *backend) pathProjectReset(ctx context.Context, req *logical.Request, da
 	if envKey == "" {
 		return nil, errors.New("env is required")
 	}
-	config, err := b.config(ctx, req.Storage)
+	client, err := b.client(ctx, req.Storage, connectionName(data))
 	if err != nil {
 		return &logical.Response{
 			Data: map[string]interface{}{
@@ -111,24 +111,24 @@ func (b *backend) pathProjectReset(ctx context.Context, req *logical.Request, da
 		}, nil
 	}
 
-	client, err := newClient(config, false)
-	if err != nil {
-		return nil, err
-	}
-
-	var env ldapi.Environment
+	var envRaw interface{}
 	switch reset := resetType; reset {
 	case "mobile":
-		env, _, err = client.ld.EnvironmentsApi.ResetEnvironmentMobileKey(client.ctx, projectKey, envKey, nil)
+		envRaw, _, err = handleRateLimit(ctx, true, false, func() (interface{}, *http.Response, error) {
+			return client.ld.EnvironmentsApi.ResetEnvironmentMobileKey(client.authContext(ctx), projectKey, envKey, nil)
+		})
 		if err != nil {
 			return nil, handleLdapiErr(err)
 		}
 	case "sdk":
-		env, _, err = client.ld.EnvironmentsApi.ResetEnvironmentSDKKey(client.ctx, projectKey, envKey, nil)
+		envRaw, _, err = handleRateLimit(ctx, true, false, func() (interface{}, *http.Response, error) {
+			return client.ld.EnvironmentsApi.ResetEnvironmentSDKKey(client.authContext(ctx), projectKey, envKey, nil)
+		})
 		if err != nil {
 			return nil, handleLdapiErr(err)
 		}
 	}
+	env := envRaw.(ldapi.Environment)
 
 	return &logical.Response{
 		Data: map[string]interface{}{
@@ -138,3 +138,22 @@ func (b *backend) pathProjectReset(ctx context.Context, req *logical.Request, da
 		},
 	}, nil
 }
+
+// projectCachePrefix returns where the keys of the projects of a connection are
+// cached. Projects of the default connection keep the prefix used before
+// connections existed; the others are kept apart so that their keys are not
+// listed with them.
+func projectCachePrefix(connection string) string {
+	if connection == defaultConnection {
+		return "project/"
+	}
+	return "project-cache/" + connection + "/"
+}
+
+func projectCacheKey(connection, projectKey string) string {
+	return projectCachePrefix(connection) + projectKey
+}
+
+func (b *backend) pathProjectList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return listStorage(ctx, req.Storage, projectCachePrefix(connectionName(data)), data)
+}
@@ -7,11 +7,27 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/antihax/optional"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 	ldapi "github.com/launchdarkly/api-client-go"
 )
 
+// relayPolicyEntry is a stored relay policy. The policy is embedded so that
+// entries written before connections existed decode unchanged.
+type relayPolicyEntry struct {
+	ldapi.Policy
+	Connection string `json:"connection,omitempty"`
+}
+
+// connection returns the connection relay tokens for this policy are issued through.
+func (entry *relayPolicyEntry) connection() string {
+	if entry.Connection == "" {
+		return defaultConnection
+	}
+	return entry.Connection
+}
+
 func (b *backend) pathRelayWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	//logger := hclog.New(&hclog.LoggerOptions{})
 	if err := validateFields(req, data); err != nil {
@@ -28,10 +44,21 @@ func (b *backend) pathRelayWrite(ctx context.Context, req *logical.Request, data
 		return nil, errors.New("name is required")
 	}
 
-	var tokenPolicy ldapi.Policy
-	if err := json.Unmarshal([]byte(policy), &tokenPolicy); err != nil {
+	var tokenPolicy relayPolicyEntry
+	if err := json.Unmarshal([]byte(policy), &tokenPolicy.Policy); err != nil {
 		return nil, err
 	}
+	for _, values := range [][]string{tokenPolicy.Resources, tokenPolicy.NotResources} {
+		for _, value := range values {
+			if err := validateIdentityTemplates(value); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+	}
+	tokenPolicy.Connection = data.Get("connection").(string)
+	if resp, err := b.checkConnection(ctx, req.Storage, tokenPolicy.Connection); resp != nil || err != nil {
+		return resp, err
+	}
 
 	newEntry, err := logical.StorageEntryJSON("relay/policy/"+name, tokenPolicy)
 	if err != nil {
@@ -58,12 +85,7 @@ func (b *backend) pathRelayRead(ctx context.Context, req *logical.Request, data
 
 	name := data.Get("name").(string)
 
-	config, err := getConfig(b, ctx, req.Storage)
-	if err != nil {
-		return nil, err
-	}
-
-	var tokenPolicy ldapi.Policy
+	var tokenPolicy relayPolicyEntry
 
 	policyEntry, err := req.Storage.Get(ctx, "relay/policy/"+name)
 	if err != nil {
@@ -78,10 +100,36 @@ func (b *backend) pathRelayRead(ctx context.Context, req *logical.Request, data
 		}
 	}
 
-	token, err := CreateRelayToken(config, name, tokenPolicy)
+	connection := tokenPolicy.connection()
+	client, err := b.client(ctx, req.Storage, connection)
 	if err != nil {
-		return nil, handleLdapiErr(err)
+		return nil, err
+	}
+	config := client.config
+
+	policy, err := b.identityPolicy(req, tokenPolicy.Policy)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// Relay proxy configs have no description.
+	names := newNameTemplateData(req, name)
+	names.Policy = name
+	configName, _, err := credentialNames(config, nil, names)
+	if err != nil {
+		return nil, err
+	}
+
+	walID, wal, err := putWAL(ctx, req.Storage, walRelayKind, connection, client.ownedName(configName))
+	if err != nil {
+		return nil, err
+	}
+	token, err := CreateRelayToken(ctx, client, configName, policy)
+	if err != nil {
+		b.createFailed(ctx, req.Storage, walID, err)
+		return nil, err
 	}
+	walID = b.recordCreated(ctx, req.Storage, walID, walRelayKind, wal, token.Id)
 
 	resp := b.Secret(programmaticAPIKey).Response(map[string]interface{}{
 		"token": token.FullKey,
@@ -89,32 +137,47 @@ func (b *backend) pathRelayRead(ctx context.Context, req *logical.Request, data
 		"api_key_id":      token.Id,
 		"credential_type": "rac",
 		"secret_type":     "relay",
+		"connection":      connection,
 	})
-	resp.Secret.MaxTTL = config.MaxTTL * time.Second
-	resp.Secret.TTL = config.TTL * time.Second
+	if err := b.applyLease(resp, secretLimits(config, nil), 0); err != nil {
+		return nil, err
+	}
 
+	if err := b.putIssued(ctx, req.Storage, &issuedEntry{
+		ID:             token.Id,
+		CredentialType: "rac",
+		SecretType:     "relay",
+		Policy:         name,
+		Connection:     connection,
+		Name:           token.Name,
+		EntityID:       req.EntityID,
+		DisplayName:    req.DisplayName,
+		ExpiresAt:      b.leaseExpiry(resp.Secret.TTL),
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := framework.DeleteWAL(ctx, req.Storage, walID); err != nil {
+		return nil, err
+	}
 	return resp, nil
 }
 
 // CreatelaunchdarklyToken uses LaunchDarkly API to create a Relay Auto Config token
-func CreateRelayToken(config *launchdarklyConfig, name string, policy ldapi.Policy) (*ldapi.RelayProxyConfig, error) {
+func CreateRelayToken(ctx context.Context, client *Client, name string, policy ldapi.Policy) (*ldapi.RelayProxyConfig, error) {
 	//logger := hclog.New(&hclog.LoggerOptions{})
 
 	// Prepare request
 	newToken := ldapi.RelayProxyConfigBody{
-		Name:   name,
+		Name:   client.ownedName(name),
 		Policy: []ldapi.Policy{policy},
 	}
-	client, err := newClient(config, false)
-	if err != nil {
-		return nil, err
-	}
 
-	tokenRaw, _, err := handleRateLimit(func() (interface{}, *http.Response, error) {
-		return client.ld.RelayProxyConfigurationsApi.PostRelayAutoConfig(client.ctx, newToken)
+	tokenRaw, res, err := handleRateLimit(ctx, false, false, func() (interface{}, *http.Response, error) {
+		return client.ld.RelayProxyConfigurationsApi.PostRelayAutoConfig(client.authContext(ctx), newToken)
 	})
 	if err != nil {
-		return nil, handleLdapiErr(err)
+		return nil, createErr(res, err)
 	}
 	token := tokenRaw.(ldapi.RelayProxyConfig)
 
@@ -136,22 +199,45 @@ func (b *backend) pathRelayDelete(ctx context.Context, req *logical.Request, dat
 	return nil, nil
 }
 
+func (b *backend) pathRelayPolicyList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return listStorage(ctx, req.Storage, "relay/policy/", data)
+}
+
 // DeleteRelayToken uses the LaunchDarkly API to delete a Relay Auto Congfig token
-func DeleteRelayToken(config *launchdarklyConfig, tokenId string) error {
+func DeleteRelayToken(ctx context.Context, client *Client, tokenId string) error {
 	//logger := hclog.New(&hclog.LoggerOptions{})
 
-	client, err := newClient(config, false)
+	_, _, err := handleRateLimit(ctx, true, true, func() (interface{}, *http.Response, error) {
+		res, err := client.ld.RelayProxyConfigurationsApi.DeleteRelayProxyConfig(client.authContext(withLowPriority(ctx)), tokenId)
+		if res != nil && res.StatusCode == http.StatusNotFound {
+			// Already deleted.
+			return nil, res, nil
+		}
+		return nil, res, err
+	})
 	if err != nil {
 		return handleLdapiErr(err)
 	}
 
-	_, _, err = handleRateLimit(func() (interface{}, *http.Response, error) {
-		res, err := client.ld.RelayProxyConfigurationsApi.DeleteRelayProxyConfig(client.ctx, tokenId)
-		return nil, res, err
+	return nil
+}
+
+// ResetRelayToken gives the relay proxy config a new key, keeping its id and
+// policy. The old key keeps working until expiry, or stops at once if expiry is
+// zero.
+func ResetRelayToken(ctx context.Context, client *Client, id string, expiry time.Time) (*ldapi.RelayProxyConfig, error) {
+	opts := &ldapi.ResetRelayProxyConfigOpts{}
+	if !expiry.IsZero() {
+		opts.Expiry = optional.NewInt64(expiry.UnixNano() / int64(time.Millisecond))
+	}
+
+	tokenRaw, _, err := handleRateLimit(ctx, true, false, func() (interface{}, *http.Response, error) {
+		return client.ld.RelayProxyConfigurationsApi.ResetRelayProxyConfig(client.authContext(ctx), id, opts)
 	})
 	if err != nil {
-		return handleLdapiErr(err)
+		return nil, handleLdapiErr(err)
 	}
+	token := tokenRaw.(ldapi.RelayProxyConfig)
 
-	return nil
+	return &token, nil
 }
@@ -16,6 +16,7 @@ func TestAccessRelayCreds(t *testing.T) {
 
 	t.Run("add config", acceptanceTestEnv.addConfig)
 	t.Run("write relay policy", acceptanceTestEnv.writeRelayPolicy)
+	t.Run("list relay policies", acceptanceTestEnv.listRelayPolicies)
 	t.Run("read relay token", acceptanceTestEnv.readRelayToken)
 	t.Run("read relay no path", acceptanceTestEnv.readNonExistantRelayToken)
 }
@@ -75,3 +76,22 @@ func (e *testEnv) writeRelayPolicy(t *testing.T) {
 		t.Fatal("policy does not match")
 	}
 }
+
+func (e *testEnv) listRelayPolicies(t *testing.T) {
+	req := &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "relay/policy/",
+		Storage:   e.Storage,
+	}
+	resp, err := e.Backend.HandleRequest(e.Context, req)
+	if err != nil {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+	if resp == nil {
+		t.Fatal("expected a response")
+	}
+	keys := resp.Data["keys"].([]string)
+	if len(keys) != 1 || keys[0] != "testvault" {
+		t.Fatalf("unexpected keys: %#v", keys)
+	}
+}
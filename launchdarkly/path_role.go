@@ -2,9 +2,16 @@ package launchdarkly
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
 	"time"
 
+	"github.com/antihax/optional"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 	ldapi "github.com/launchdarkly/api-client-go"
@@ -18,120 +25,545 @@ type RoleName struct {
 	Name string
 }
 
-func (b *backend) pathRoleRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	//logger := hclog.New(&hclog.LoggerOptions{})
-	roleName := data.Get("customrole").(string)
-	tokenName := data.Get("name").(string)
-	if roleName == "" {
+// launchdarklyRoleEntry is a Vault managed role. It defines which LaunchDarkly
+// built-in role, custom roles or inline policy the tokens issued through
+// creds/<name> are bound to.
+type launchdarklyRoleEntry struct {
+	CustomRoleIds []string `json:"custom_role_ids"`
+	// InlinePolicy grants the tokens these statements instead of custom roles.
+	InlinePolicy []ldapi.Statement `json:"inline_policy,omitempty"`
+	BuiltinRole  string            `json:"builtin_role,omitempty"`
+	// PersonalToken is stored negated so that roles written before it existed
+	// keep issuing service tokens.
+	PersonalToken     bool          `json:"personal_token,omitempty"`
+	TokenName         string        `json:"token_name"`
+	TTL               time.Duration `json:"ttl"`
+	MaxTTL            time.Duration `json:"max_ttl"`
+	DefaultApiVersion int           `json:"default_api_version"`
+	Connection        string        `json:"connection"`
+	IdleTimeout       time.Duration `json:"idle_timeout"`
+	Period            time.Duration `json:"period"`
+	// NonRenewable is stored negated so that roles written before it existed
+	// stay renewable.
+	NonRenewable bool `json:"non_renewable"`
+
+	NameTemplate        string `json:"name_template"`
+	DescriptionTemplate string `json:"description_template"`
+
+	// AllowedProjects and AllowedEnvironments are the glob patterns the
+	// values of the {{project}} and {{env}} placeholders of the inline policy
+	// must match.
+	AllowedProjects     []string `json:"allowed_projects,omitempty"`
+	AllowedEnvironments []string `json:"allowed_environments,omitempty"`
+}
+
+const (
+	projectPlaceholder     = "{{project}}"
+	environmentPlaceholder = "{{env}}"
+)
+
+// ldKeyRegex matches LaunchDarkly project and environment keys.
+var ldKeyRegex = regexp.MustCompile(`^[\w.-]+$`)
+
+func (b *backend) pathRoleWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if err := validateFields(req, data); err != nil {
+		return nil, logical.CodedError(422, err.Error())
+	}
+
+	name := data.Get("name").(string)
+	if name == "" {
 		return nil, errors.New("name is required")
 	}
 
-	config, err := getConfig(b, ctx, req.Storage)
+	role, err := b.role(ctx, req.Storage, name)
 	if err != nil {
 		return nil, err
 	}
+	if role == nil {
+		role = &launchdarklyRoleEntry{
+			TokenName:         data.GetDefaultOrZero("token_name").(string),
+			DefaultApiVersion: data.GetDefaultOrZero("default_api_version").(int),
+		}
+	}
 
-	var roleEntry ldapi.Token
-	entry, err := req.Storage.Get(ctx, "role/"+roleName)
-	if err != nil {
-		return nil, err
+	if err := role.Update(data); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if _, ok := data.GetOk("connection"); ok {
+		if resp, err := b.checkConnection(ctx, req.Storage, role.Connection); resp != nil || err != nil {
+			return resp, err
+		}
 	}
-	if entry != nil {
-		if err := entry.DecodeJSON(&roleEntry); err != nil {
+
+	var resp *logical.Response
+	_, idsChanged := data.GetOk("custom_role_ids")
+	_, connectionChanged := data.GetOk("connection")
+	if len(role.CustomRoleIds) > 0 && (idsChanged || connectionChanged) {
+		unknown, warning, err := b.unknownCustomRoles(ctx, req.Storage, role)
+		if err != nil {
 			return nil, err
 		}
+		if len(unknown) > 0 {
+			return logical.ErrorResponse(fmt.Sprintf("unknown LaunchDarkly custom roles: %s, see customroles/ for the available ones", strings.Join(unknown, ", "))), nil
+		}
+		if warning != "" {
+			resp = &logical.Response{}
+			resp.AddWarning(warning)
+		}
 	}
 
-	token, err := CreateRoleToken(config, roleName, tokenName)
+	entry, err := logical.StorageEntryJSON("role/"+name, role)
 	if err != nil {
 		return nil, err
 	}
 
-	resp := b.Secret(programmaticAPIKey).Response(map[string]interface{}{
-		"token": token.Token,
-	}, map[string]interface{}{
-		"api_key_id":      token.Id,
-		"credential_type": "api",
-		"secret_type":     "role",
-	})
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
 
-	if config.TTL != 0 {
-		resp.Secret.TTL = config.TTL * time.Second
+// unknownCustomRoles returns the custom_role_ids of the role that LaunchDarkly
+// does not know. If the connection of the role is not configured or usable
+// yet, nothing can be checked and a warning is returned instead.
+func (b *backend) unknownCustomRoles(ctx context.Context, s logical.Storage, role *launchdarklyRoleEntry) (unknown []string, warning string, err error) {
+	config, err := b.config(ctx, s, role.connection())
+	if err != nil {
+		return nil, "", err
+	}
+	if config == nil {
+		return nil, fmt.Sprintf("connection %q is not configured, custom_role_ids could not be checked", role.connection()), nil
+	}
+	client, err := b.client(ctx, s, role.connection())
+	if err != nil {
+		return nil, fmt.Sprintf("custom_role_ids could not be checked: %v", err), nil
 	}
 
-	if config.MaxTTL != 0 {
-		resp.Secret.MaxTTL = config.MaxTTL * time.Second
+	for _, key := range role.CustomRoleIds {
+		customRole, err := GetCustomRole(ctx, client, key)
+		if err != nil {
+			return nil, "", fmt.Errorf("could not look up custom role %q: %v", key, err)
+		}
+		if customRole == nil {
+			unknown = append(unknown, key)
+		}
 	}
+	return unknown, "", nil
+}
 
-	return resp, nil
+func (b *backend) pathRoleRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+
+	role, err := b.role(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
+
+	inlinePolicy := ""
+	if len(role.InlinePolicy) > 0 {
+		policy, err := json.Marshal(role.InlinePolicy)
+		if err != nil {
+			return nil, err
+		}
+		inlinePolicy = string(policy)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"custom_role_ids":      role.CustomRoleIds,
+			"inline_policy":        inlinePolicy,
+			"builtin_role":         role.BuiltinRole,
+			"service_token":        !role.PersonalToken,
+			"token_name":           role.TokenName,
+			"ttl":                  int64(role.TTL.Seconds()),
+			"max_ttl":              int64(role.MaxTTL.Seconds()),
+			"default_api_version":  role.DefaultApiVersion,
+			"connection":           role.connection(),
+			"idle_timeout":         int64(role.IdleTimeout.Seconds()),
+			"period":               int64(role.Period.Seconds()),
+			"renewable":            !role.NonRenewable,
+			"name_template":        role.NameTemplate,
+			"description_template": role.DescriptionTemplate,
+			"allowed_projects":     role.AllowedProjects,
+			"allowed_environments": role.AllowedEnvironments,
+		},
+	}, nil
 }
 
 func (b *backend) pathRoleDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	//logger := hclog.New(&hclog.LoggerOptions{})
-	roleName := data.Get("customrole").(string)
-	tokenName := data.Get("name").(string)
-	if roleName == "" {
+	name := data.Get("name").(string)
+	if name == "" {
 		return nil, errors.New("name is required")
 	}
 
-	config, err := getConfig(b, ctx, req.Storage)
-	if err != nil {
+	if err := req.Storage.Delete(ctx, "role/"+name); err != nil {
 		return nil, err
 	}
+	return nil, nil
+}
 
-	token, err := CreateRoleToken(config, roleName, tokenName)
-	if err != nil {
+func (b *backend) pathRoleList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return listStorage(ctx, req.Storage, "role/", data)
+}
+
+// Update applies the fields present in the request to the role.
+func (role *launchdarklyRoleEntry) Update(data *framework.FieldData) error {
+	if v, ok := data.GetOk("custom_role_ids"); ok {
+		role.CustomRoleIds = v.([]string)
+	}
+	if v, ok := data.GetOk("inline_policy"); ok {
+		statements, err := parseInlinePolicy(v.(string))
+		if err != nil {
+			return err
+		}
+		role.InlinePolicy = statements
+	}
+	if v, ok := data.GetOk("builtin_role"); ok {
+		role.BuiltinRole = v.(string)
+	}
+	switch role.BuiltinRole {
+	case "", "reader", "writer", "admin":
+	default:
+		return fmt.Errorf("builtin_role must be one of reader, writer or admin, got %q", role.BuiltinRole)
+	}
+
+	// LaunchDarkly takes exactly one of a built-in role, custom roles or an
+	// inline role.
+	permissions := 0
+	for _, set := range []bool{role.BuiltinRole != "", len(role.CustomRoleIds) > 0, len(role.InlinePolicy) > 0} {
+		if set {
+			permissions++
+		}
+	}
+	switch {
+	case permissions == 0:
+		return errors.New("one of builtin_role, custom_role_ids or inline_policy is required")
+	case permissions > 1:
+		return errors.New("only one of builtin_role, custom_role_ids and inline_policy can be used")
+	}
+
+	if v, ok := data.GetOk("service_token"); ok {
+		role.PersonalToken = !v.(bool)
+	}
+
+	if v, ok := data.GetOk("allowed_projects"); ok {
+		role.AllowedProjects = v.([]string)
+	}
+	if v, ok := data.GetOk("allowed_environments"); ok {
+		role.AllowedEnvironments = v.([]string)
+	}
+	for field, patterns := range map[string][]string{
+		"allowed_projects":     role.AllowedProjects,
+		"allowed_environments": role.AllowedEnvironments,
+	} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid %s pattern %q: %v", field, pattern, err)
+			}
+		}
+	}
+	usesProject, usesEnvironment := role.placeholders()
+	if usesProject && len(role.AllowedProjects) == 0 {
+		return errors.New("inline_policy uses {{project}}, set allowed_projects to the projects it can be issued for")
+	}
+	if usesEnvironment && len(role.AllowedEnvironments) == 0 {
+		return errors.New("inline_policy uses {{env}}, set allowed_environments to the environments it can be issued for")
+	}
+
+	if v, ok := data.GetOk("token_name"); ok {
+		role.TokenName = v.(string)
+	}
+	if role.TokenName == "" {
+		return errors.New("token_name cannot be empty")
+	}
+
+	if v, ok := data.GetOk("ttl"); ok {
+		role.TTL = time.Duration(v.(int)) * time.Second
+	}
+	if v, ok := data.GetOk("max_ttl"); ok {
+		role.MaxTTL = time.Duration(v.(int)) * time.Second
+	}
+	if role.MaxTTL != 0 && role.TTL > role.MaxTTL {
+		return errors.New("ttl cannot be greater than max_ttl")
+	}
+	if v, ok := data.GetOk("period"); ok {
+		if v.(int) < 0 {
+			return errors.New("period cannot be negative")
+		}
+		role.Period = time.Duration(v.(int)) * time.Second
+	}
+	if v, ok := data.GetOk("renewable"); ok {
+		role.NonRenewable = !v.(bool)
+	}
+	if role.Period > 0 && role.NonRenewable {
+		return errors.New("period requires renewable leases")
+	}
+
+	if v, ok := data.GetOk("default_api_version"); ok {
+		role.DefaultApiVersion = v.(int)
+	}
+
+	if v, ok := data.GetOk("connection"); ok {
+		role.Connection = v.(string)
+	}
+
+	if v, ok := data.GetOk("idle_timeout"); ok {
+		if v.(int) < 0 {
+			return errors.New("idle_timeout cannot be negative")
+		}
+		role.IdleTimeout = time.Duration(v.(int)) * time.Second
+	}
+
+	if v, ok := data.GetOk("name_template"); ok {
+		if err := validateTemplate("name_template", v.(string)); err != nil {
+			return err
+		}
+		role.NameTemplate = v.(string)
+	}
+	if v, ok := data.GetOk("description_template"); ok {
+		if err := validateTemplate("description_template", v.(string)); err != nil {
+			return err
+		}
+		role.DescriptionTemplate = v.(string)
+	}
+
+	return nil
+}
+
+// parseInlinePolicy decodes a JSON list of LaunchDarkly policy statements and
+// checks that LaunchDarkly would accept them. An empty string clears the
+// policy.
+func parseInlinePolicy(raw string) ([]ldapi.Statement, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	var statements []ldapi.Statement
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&statements); err != nil {
+		return nil, fmt.Errorf("inline_policy must be a JSON list of policy statements: %v", err)
+	}
+	if len(statements) == 0 {
+		return nil, errors.New("inline_policy must contain at least one statement")
+	}
+
+	for i, statement := range statements {
+		if statement.Effect != "allow" && statement.Effect != "deny" {
+			return nil, fmt.Errorf("inline_policy statement %d: effect must be allow or deny, got %q", i, statement.Effect)
+		}
+		if (len(statement.Resources) == 0) == (len(statement.NotResources) == 0) {
+			return nil, fmt.Errorf("inline_policy statement %d: exactly one of resources and notResources is required", i)
+		}
+		if (len(statement.Actions) == 0) == (len(statement.NotActions) == 0) {
+			return nil, fmt.Errorf("inline_policy statement %d: exactly one of actions and notActions is required", i)
+		}
+		for _, values := range [][]string{statement.Resources, statement.NotResources, statement.Actions, statement.NotActions} {
+			for _, value := range values {
+				if strings.TrimSpace(value) == "" {
+					return nil, fmt.Errorf("inline_policy statement %d: resources and actions cannot be empty", i)
+				}
+			}
+		}
+		for _, values := range [][]string{statement.Actions, statement.NotActions} {
+			for _, value := range values {
+				if strings.Contains(value, "{{") {
+					return nil, fmt.Errorf("inline_policy statement %d: placeholders can only be used in resources", i)
+				}
+			}
+		}
+		for _, values := range [][]string{statement.Resources, statement.NotResources} {
+			for _, value := range values {
+				if err := validateIdentityTemplates(value); err != nil {
+					return nil, fmt.Errorf("inline_policy statement %d: %v", i, err)
+				}
+				rest := identityTemplateRegex.ReplaceAllString(value, "")
+				rest = strings.NewReplacer(projectPlaceholder, "", environmentPlaceholder, "").Replace(rest)
+				if strings.Contains(rest, "{{") || strings.Contains(rest, "}}") {
+					return nil, fmt.Errorf("inline_policy statement %d: unknown placeholder in %q, only %s, %s and identity templates are supported", i, value, projectPlaceholder, environmentPlaceholder)
+				}
+			}
+		}
+	}
+	return statements, nil
+}
+
+// placeholders reports whether the resources of the inline policy use the
+// {{project}} and {{env}} placeholders.
+func (role *launchdarklyRoleEntry) placeholders() (project, environment bool) {
+	for _, statement := range role.InlinePolicy {
+		for _, values := range [][]string{statement.Resources, statement.NotResources} {
+			for _, value := range values {
+				project = project || strings.Contains(value, projectPlaceholder)
+				environment = environment || strings.Contains(value, environmentPlaceholder)
+			}
+		}
+	}
+	return project, environment
+}
+
+// scopedPolicy returns the inline policy with its placeholders filled in by
+// the project and environment asked for, after checking them against the
+// allowed patterns of the role.
+func (role *launchdarklyRoleEntry) scopedPolicy(project, environment string) ([]ldapi.Statement, error) {
+	usesProject, usesEnvironment := role.placeholders()
+	if err := checkScope("project", project, usesProject, role.AllowedProjects); err != nil {
 		return nil, err
 	}
+	if err := checkScope("environment", environment, usesEnvironment, role.AllowedEnvironments); err != nil {
+		return nil, err
+	}
+	if !usesProject && !usesEnvironment {
+		return role.InlinePolicy, nil
+	}
 
-	resp := b.Secret(programmaticAPIKey).Response(map[string]interface{}{
-		"token": token.Token,
-	}, map[string]interface{}{
-		"api_key_id":      token.Id,
-		"credential_type": "api",
-		"secret_type":     "role",
-	})
+	replacer := strings.NewReplacer(projectPlaceholder, project, environmentPlaceholder, environment)
+	replace := func(values []string) []string {
+		if values == nil {
+			return nil
+		}
+		replaced := make([]string, len(values))
+		for i, value := range values {
+			replaced[i] = replacer.Replace(value)
+		}
+		return replaced
+	}
 
-	resp.Secret.MaxTTL = config.MaxTTL
-	resp.Secret.TTL = config.TTL
-	return resp, nil
+	statements := make([]ldapi.Statement, len(role.InlinePolicy))
+	for i, statement := range role.InlinePolicy {
+		statement.Resources = replace(statement.Resources)
+		statement.NotResources = replace(statement.NotResources)
+		statements[i] = statement
+	}
+	return statements, nil
 }
 
-// CreatelaunchdarklyToken uses launchdarkly API to create an API token
-func CreateRoleToken(config *launchdarklyConfig, role string, name string) (*ldapi.Token, error) {
-	//logger := hclog.New(&hclog.LoggerOptions{})
+// checkScope checks a project or environment key given to creds against a
+// role whose policy does or does not use its placeholder.
+func checkScope(field, value string, used bool, allowed []string) error {
+	switch {
+	case !used && value != "":
+		return fmt.Errorf("this role does not take a %s", field)
+	case !used:
+		return nil
+	case value == "":
+		return fmt.Errorf("%s is required for this role", field)
+	case !ldKeyRegex.MatchString(value):
+		return fmt.Errorf("invalid %s key %q", field, value)
+	}
+	for _, pattern := range allowed {
+		if ok, _ := path.Match(pattern, value); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s %q is not allowed for this role", field, value)
+}
 
-	// Prepare request
-	newToken := ldapi.TokenBody{
-		Name:          name,
-		CustomRoleIds: []string{role},
-		ServiceToken:  true,
+// connection returns the connection tokens for this role are issued through.
+func (role *launchdarklyRoleEntry) connection() string {
+	if role.Connection == "" {
+		return defaultConnection
 	}
-	client, err := newClient(config, false)
+	return role.Connection
+}
+
+func (b *backend) role(ctx context.Context, s logical.Storage, name string) (*launchdarklyRoleEntry, error) {
+	entry, err := s.Get(ctx, "role/"+name)
 	if err != nil {
 		return nil, err
 	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	role := &launchdarklyRoleEntry{}
+	if err := entry.DecodeJSON(role); err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+// CreateRoleToken uses launchdarkly API to create an API token for a role
+func CreateRoleToken(ctx context.Context, client *Client, role *launchdarklyRoleEntry, name string) (*ldapi.Token, error) {
+	//logger := hclog.New(&hclog.LoggerOptions{})
+
+	// Prepare request
+	newToken := ldapi.TokenBody{
+		Name:              client.ownedName(name),
+		CustomRoleIds:     role.CustomRoleIds,
+		InlineRole:        role.InlinePolicy,
+		Role:              role.BuiltinRole,
+		ServiceToken:      !role.PersonalToken,
+		DefaultApiVersion: int32(role.DefaultApiVersion),
+	}
 
-	token, _, err := client.ld.AccessTokensApi.PostToken(client.ctx, newToken)
+	tokenRaw, res, err := handleRateLimit(ctx, false, false, func() (interface{}, *http.Response, error) {
+		return client.ld.AccessTokensApi.PostToken(client.authContext(ctx), newToken)
+	})
 	if err != nil {
-		return nil, handleLdapiErr(err)
+		return nil, createErr(res, err)
 	}
+	token := tokenRaw.(ldapi.Token)
 
 	return &token, nil
 }
 
-func DeleteRoleToken(config *launchdarklyConfig, id string) (*ldapi.Token, error) {
+func DeleteRoleToken(ctx context.Context, client *Client, id string) (*ldapi.Token, error) {
 	//logger := hclog.New(&hclog.LoggerOptions{})
 
-	client, err := newClient(config, false)
+	_, _, err := handleRateLimit(ctx, true, true, func() (interface{}, *http.Response, error) {
+		res, err := client.ld.AccessTokensApi.DeleteToken(client.authContext(withLowPriority(ctx)), id)
+		if res != nil && res.StatusCode == http.StatusNotFound {
+			// Already deleted.
+			return nil, res, nil
+		}
+		return nil, res, err
+	})
 	if err != nil {
-		return nil, err
+		return nil, handleLdapiErr(err)
+	}
+
+	return nil, nil
+}
+
+// ResetRoleToken gives the token a new secret value, keeping its id and custom
+// roles. The old value keeps working until expiry, or stops at once if expiry
+// is zero.
+func ResetRoleToken(ctx context.Context, client *Client, id string, expiry time.Time) (*ldapi.Token, error) {
+	opts := &ldapi.ResetTokenOpts{}
+	if !expiry.IsZero() {
+		opts.Expiry = optional.NewInt64(expiry.UnixNano() / int64(time.Millisecond))
 	}
 
-	_, err = client.ld.AccessTokensApi.DeleteToken(client.ctx, id)
+	tokenRaw, _, err := handleRateLimit(ctx, true, false, func() (interface{}, *http.Response, error) {
+		return client.ld.AccessTokensApi.ResetToken(client.authContext(ctx), id, opts)
+	})
 	if err != nil {
 		return nil, handleLdapiErr(err)
 	}
+	token := tokenRaw.(ldapi.Token)
 
-	return nil, nil
+	return &token, nil
+}
+
+// SetTokenDescription sets the description of a token. The token creation API
+// does not take one.
+func SetTokenDescription(ctx context.Context, client *Client, id string, description string) error {
+	var value interface{} = description
+	patch := []ldapi.PatchOperation{{Op: "replace", Path: "/description", Value: &value}}
+
+	_, _, err := handleRateLimit(ctx, true, true, func() (interface{}, *http.Response, error) {
+		return client.ld.AccessTokensApi.PatchToken(client.authContext(ctx), id, patch)
+	})
+	if err != nil {
+		return handleLdapiErr(err)
+	}
+	return nil
 }
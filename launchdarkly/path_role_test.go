@@ -1,27 +1,48 @@
 package launchdarkly
 
 import (
-	"strings"
+	"net/http"
+	"reflect"
 	"testing"
 
 	"github.com/hashicorp/vault/sdk/logical"
+	ldapi "github.com/launchdarkly/api-client-go"
 )
 
-func TestRoleToken(t *testing.T) {
+func TestRole(t *testing.T) {
 
 	acceptanceTestEnv, err := newTestAccEnv()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	t.Run("add config", acceptanceTestEnv.addConfig)
-	t.Run("read role token", acceptanceTestEnv.readRoleToken)
+	t.Run("write role", acceptanceTestEnv.writeRole)
+	t.Run("read role", acceptanceTestEnv.readRole)
+	t.Run("delete role", acceptanceTestEnv.deleteRole)
 }
 
-func (e *testEnv) readRoleToken(t *testing.T) {
+const roleTestName = "test-vault-role"
+
+func (e *testEnv) writeRole(t *testing.T) {
+	req := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "role/" + roleTestName,
+		Storage:   e.Storage,
+		Data: map[string]interface{}{
+			"custom_role_ids": "test-vault-role",
+			"ttl":             "1h",
+		},
+	}
+	resp, err := e.Backend.HandleRequest(e.Context, req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+}
+
+func (e *testEnv) readRole(t *testing.T) {
 	req := &logical.Request{
 		Operation: logical.ReadOperation,
-		Path:      "role/test-vault-role",
+		Path:      "role/" + roleTestName,
 		Storage:   e.Storage,
 	}
 	resp, err := e.Backend.HandleRequest(e.Context, req)
@@ -31,7 +52,266 @@ func (e *testEnv) readRoleToken(t *testing.T) {
 	if resp == nil {
 		t.Fatal("expected a response")
 	}
-	if resp.Data["token"] == "" || !strings.HasPrefix(resp.Data["token"].(string), "api-") {
-		t.Fatal("token does not match expected format")
+	if !reflect.DeepEqual(resp.Data["custom_role_ids"], []string{"test-vault-role"}) {
+		t.Fatalf("unexpected custom_role_ids: %#v", resp.Data["custom_role_ids"])
+	}
+	if resp.Data["token_name"] != "vault-generated" {
+		t.Fatalf("unexpected token_name: %#v", resp.Data["token_name"])
+	}
+	if resp.Data["ttl"] != int64(3600) {
+		t.Fatalf("unexpected ttl: %#v", resp.Data["ttl"])
+	}
+}
+
+func (e *testEnv) deleteRole(t *testing.T) {
+	req := &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "role/" + roleTestName,
+		Storage:   e.Storage,
+	}
+	resp, err := e.Backend.HandleRequest(e.Context, req)
+	if err != nil {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role/" + roleTestName,
+		Storage:   e.Storage,
+	}
+	resp, err = e.Backend.HandleRequest(e.Context, req)
+	if err != nil {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+	if resp != nil {
+		t.Fatal("expected role to be deleted")
+	}
+}
+
+func TestRoleList(t *testing.T) {
+	acceptanceTestEnv, err := newTestAccEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"alpha", "bravo", "charlie"} {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/" + name,
+			Storage:   acceptanceTestEnv.Storage,
+			Data: map[string]interface{}{
+				"custom_role_ids": "test-vault-role",
+			},
+		}
+		resp, err := acceptanceTestEnv.Backend.HandleRequest(acceptanceTestEnv.Context, req)
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+		}
+	}
+
+	req := &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "role/",
+		Storage:   acceptanceTestEnv.Storage,
+		Data: map[string]interface{}{
+			"after": "alpha",
+			"limit": 1,
+		},
+	}
+	resp, err := acceptanceTestEnv.Backend.HandleRequest(acceptanceTestEnv.Context, req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: resp: %#v\nerr:%v", resp, err)
+	}
+	if !reflect.DeepEqual(resp.Data["keys"], []string{"bravo"}) {
+		t.Fatalf("unexpected keys: %#v", resp.Data["keys"])
+	}
+}
+
+func TestRoleInlinePolicy(t *testing.T) {
+	var created ldapi.TokenBody
+	server := newFakeLD(t, map[string]http.HandlerFunc{
+		"/api/v2/tokens": func(w http.ResponseWriter, r *http.Request) {
+			decodeJSON(t, r, &created)
+			writeJSON(w, http.StatusCreated, ldapi.Token{Id: "issued", Token: "api-issued"})
+		},
+	})
+
+	e, err := newTestAccEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.mustRequest(t, logical.UpdateOperation, "config", map[string]interface{}{
+		"access_token": "api-1234",
+		"base_uri":     server.URL,
+		"skip_verify":  true,
+	})
+
+	for name, policy := range map[string]string{
+		"not json":       `allow everything`,
+		"empty":          `[]`,
+		"unknown field":  `[{"effect": "allow", "resource": ["proj/*"], "actions": ["*"]}]`,
+		"bad effect":     `[{"effect": "permit", "resources": ["proj/*"], "actions": ["*"]}]`,
+		"no resources":   `[{"effect": "allow", "actions": ["*"]}]`,
+		"both actions":   `[{"effect": "allow", "resources": ["proj/*"], "actions": ["*"], "notActions": ["deleteProject"]}]`,
+		"empty resource": `[{"effect": "allow", "resources": [""], "actions": ["*"]}]`,
+	} {
+		resp, err := e.request(logical.UpdateOperation, "role/inline", map[string]interface{}{"inline_policy": policy})
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("%s: expected the policy to be rejected, got %#v, %v", name, resp, err)
+		}
+	}
+
+	policy := `[{"effect": "allow", "resources": ["proj/mobile:env/*:flag/*"], "actions": ["updateOn"]}]`
+	if resp, _ := e.request(logical.UpdateOperation, "role/inline", map[string]interface{}{
+		"inline_policy":   policy,
+		"custom_role_ids": "reader",
+	}); resp == nil || !resp.IsError() {
+		t.Fatal("expected custom_role_ids and inline_policy to be exclusive")
+	}
+	e.mustRequest(t, logical.UpdateOperation, "role/inline", map[string]interface{}{"inline_policy": policy})
+
+	resp := e.mustRequest(t, logical.ReadOperation, "role/inline", nil)
+	if resp.Data["inline_policy"] != `[{"resources":["proj/mobile:env/*:flag/*"],"actions":["updateOn"],"effect":"allow"}]` {
+		t.Fatalf("unexpected inline_policy: %#v", resp.Data["inline_policy"])
+	}
+
+	e.mustRequest(t, logical.ReadOperation, "creds/inline", nil)
+	expected := []ldapi.Statement{{Effect: "allow", Resources: []string{"proj/mobile:env/*:flag/*"}, Actions: []string{"updateOn"}}}
+	if !reflect.DeepEqual(created.InlineRole, expected) || len(created.CustomRoleIds) != 0 {
+		t.Fatalf("unexpected token body: %#v", created)
+	}
+
+	// Switching the role to custom roles clears the inline policy.
+	e.mustRequest(t, logical.UpdateOperation, "role/inline", map[string]interface{}{
+		"inline_policy":   "",
+		"custom_role_ids": "reader",
+	})
+	if resp := e.mustRequest(t, logical.ReadOperation, "role/inline", nil); resp.Data["inline_policy"] != "" {
+		t.Fatalf("expected the inline policy to be cleared, got %#v", resp.Data["inline_policy"])
+	}
+}
+
+func TestRoleBuiltinRole(t *testing.T) {
+	var created []ldapi.TokenBody
+	server := newFakeLD(t, map[string]http.HandlerFunc{
+		"/api/v2/tokens": func(w http.ResponseWriter, r *http.Request) {
+			var body ldapi.TokenBody
+			decodeJSON(t, r, &body)
+			created = append(created, body)
+			writeJSON(w, http.StatusCreated, ldapi.Token{Id: "issued", Token: "api-issued"})
+		},
+	})
+
+	e, err := newTestAccEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.mustRequest(t, logical.UpdateOperation, "config", map[string]interface{}{
+		"access_token": "api-1234",
+		"base_uri":     server.URL,
+		"skip_verify":  true,
+	})
+
+	for name, data := range map[string]map[string]interface{}{
+		"unknown role":  {"builtin_role": "owner"},
+		"custom roles":  {"builtin_role": "reader", "custom_role_ids": "reader"},
+		"inline policy": {"builtin_role": "reader", "inline_policy": `[{"effect": "allow", "resources": ["proj/*"], "actions": ["*"]}]`},
+	} {
+		if resp, err := e.request(logical.UpdateOperation, "role/builtin", data); err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("%s: expected the role to be rejected, got %#v, %v", name, resp, err)
+		}
+	}
+
+	e.mustRequest(t, logical.UpdateOperation, "role/builtin", map[string]interface{}{
+		"builtin_role":  "writer",
+		"service_token": false,
+	})
+	resp := e.mustRequest(t, logical.ReadOperation, "role/builtin", nil)
+	if resp.Data["builtin_role"] != "writer" || resp.Data["service_token"] != false {
+		t.Fatalf("unexpected role: %#v", resp.Data)
+	}
+	e.mustRequest(t, logical.UpdateOperation, "role/custom", map[string]interface{}{
+		"custom_role_ids": "reader",
+	})
+
+	e.mustRequest(t, logical.ReadOperation, "creds/builtin", nil)
+	e.mustRequest(t, logical.ReadOperation, "creds/custom", nil)
+	if body := created[0]; body.Role != "writer" || body.ServiceToken || len(body.CustomRoleIds) != 0 {
+		t.Fatalf("expected a personal token with the writer role, got %#v", body)
+	}
+	if body := created[1]; body.Role != "" || !body.ServiceToken {
+		t.Fatalf("expected roles to issue service tokens by default, got %#v", body)
+	}
+}
+
+func TestRoleScopedPolicy(t *testing.T) {
+	var created ldapi.TokenBody
+	server := newFakeLD(t, map[string]http.HandlerFunc{
+		"/api/v2/tokens": func(w http.ResponseWriter, r *http.Request) {
+			decodeJSON(t, r, &created)
+			writeJSON(w, http.StatusCreated, ldapi.Token{Id: "issued", Token: "api-issued"})
+		},
+	})
+
+	e, err := newTestAccEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.mustRequest(t, logical.UpdateOperation, "config", map[string]interface{}{
+		"access_token": "api-1234",
+		"base_uri":     server.URL,
+		"skip_verify":  true,
+	})
+
+	policy := `[{"effect": "allow", "resources": ["proj/{{project}}:env/{{env}}:flag/*"], "actions": ["updateOn"]}]`
+	for name, data := range map[string]map[string]interface{}{
+		"no allowed projects": {"inline_policy": policy, "allowed_environments": "*"},
+		"unknown placeholder": {"inline_policy": `[{"effect": "allow", "resources": ["proj/{{team}}"], "actions": ["*"]}]`},
+		"placeholder action":  {"inline_policy": `[{"effect": "allow", "resources": ["proj/*"], "actions": ["{{project}}"]}]`},
+		"bad pattern":         {"inline_policy": policy, "allowed_projects": "[", "allowed_environments": "*"},
+	} {
+		if resp, err := e.request(logical.UpdateOperation, "role/scoped", data); err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("%s: expected the role to be rejected, got %#v, %v", name, resp, err)
+		}
+	}
+	e.mustRequest(t, logical.UpdateOperation, "role/scoped", map[string]interface{}{
+		"inline_policy":        policy,
+		"allowed_projects":     "mobile-*,web",
+		"allowed_environments": "staging",
+	})
+
+	for name, data := range map[string]map[string]interface{}{
+		"missing project":     {"environment": "staging"},
+		"project not allowed": {"project": "billing", "environment": "staging"},
+		"env not allowed":     {"project": "web", "environment": "production"},
+		"invalid key":         {"project": "web:env/*", "environment": "staging"},
+	} {
+		if resp, err := e.request(logical.ReadOperation, "creds/scoped", data); err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("%s: expected creds to be refused, got %#v, %v", name, resp, err)
+		}
+	}
+
+	e.mustRequest(t, logical.ReadOperation, "creds/scoped", map[string]interface{}{
+		"project":     "mobile-ios",
+		"environment": "staging",
+	})
+	if len(created.InlineRole) != 1 || !reflect.DeepEqual(created.InlineRole[0].Resources, []string{"proj/mobile-ios:env/staging:flag/*"}) {
+		t.Fatalf("unexpected inline role: %#v", created.InlineRole)
+	}
+	resp := e.mustRequest(t, logical.ReadOperation, "issued/issued", nil)
+	if resp.Data["project"] != "mobile-ios" || resp.Data["environment"] != "staging" {
+		t.Fatalf("expected the scope to be recorded, got %#v", resp.Data)
+	}
+	resp = e.mustRequest(t, logical.ReadOperation, "role/scoped", nil)
+	if resp.Data["inline_policy"] != `[{"resources":["proj/{{project}}:env/{{env}}:flag/*"],"actions":["updateOn"],"effect":"allow"}]` {
+		t.Fatalf("expected the stored policy to keep its placeholders, got %#v", resp.Data["inline_policy"])
+	}
+
+	e.mustRequest(t, logical.UpdateOperation, "role/plain", map[string]interface{}{"custom_role_ids": "reader"})
+	if resp, err := e.request(logical.ReadOperation, "creds/plain", map[string]interface{}{"project": "web"}); err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected a project to be refused by a role without placeholders, got %#v, %v", resp, err)
 	}
 }
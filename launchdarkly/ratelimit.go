@@ -0,0 +1,159 @@
+package launchdarkly
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// defaultRateLimit and defaultRateLimitBurst apply when the connection does
+	// not set rate_limit or rate_limit_burst.
+	defaultRateLimit      = 10
+	defaultRateLimitBurst = 10
+)
+
+type priorityKey struct{}
+
+// withLowPriority marks the LaunchDarkly calls made with ctx as background work,
+// such as revocations, that must not hold up interactive issuance.
+func withLowPriority(ctx context.Context) context.Context {
+	return context.WithValue(ctx, priorityKey{}, true)
+}
+
+func isLowPriority(ctx context.Context) bool {
+	low, _ := ctx.Value(priorityKey{}).(bool)
+	return low
+}
+
+// rateLimiter is a token bucket shared by every call made through a connection.
+// It starts at the configured rate and slows down to what LaunchDarkly reports
+// is left in the current rate limit window. Low priority calls run one at a time
+// and get at most half of the rate, so they cannot starve interactive calls.
+type rateLimiter struct {
+	limit rate.Limit
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+
+	shared *rate.Limiter
+	low    *rate.Limiter
+	lowQ   chan struct{}
+}
+
+func newRateLimiter(config *launchdarklyConfig) *rateLimiter {
+	limit := rate.Limit(config.RateLimit)
+	if limit <= 0 {
+		limit = defaultRateLimit
+	}
+	burst := config.RateLimitBurst
+	if burst <= 0 {
+		burst = defaultRateLimitBurst
+	}
+
+	return &rateLimiter{
+		limit:  limit,
+		shared: rate.NewLimiter(limit, burst),
+		low:    rate.NewLimiter(limit/2, 1),
+		lowQ:   make(chan struct{}, 1),
+	}
+}
+
+// rateLimiter returns the rate limiter of a connection. It is replaced when the
+// rate_limit or rate_limit_burst of the connection has changed.
+func (b *backend) rateLimiter(connection string, config *launchdarklyConfig) *rateLimiter {
+	b.limiterMutex.Lock()
+	defer b.limiterMutex.Unlock()
+
+	limiter := newRateLimiter(config)
+	if current, ok := b.limiters[connection]; ok && current.limit == limiter.limit && current.shared.Burst() == limiter.shared.Burst() {
+		return current
+	}
+	b.limiters[connection] = limiter
+	return limiter
+}
+
+// wait blocks until a call may be sent or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if isLowPriority(ctx) {
+		select {
+		case l.lowQ <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		defer func() { <-l.lowQ }()
+
+		if err := l.low.Wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	l.mu.Lock()
+	pause := time.Until(l.pausedUntil)
+	l.mu.Unlock()
+	if pause > 0 {
+		timer := time.NewTimer(pause)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+
+	return l.shared.Wait(ctx)
+}
+
+// observe adapts the rate to the X-Ratelimit-Global-Remaining and
+// X-Ratelimit-Reset headers of a LaunchDarkly response. Route limits are left
+// to the retries in handleRateLimit so that one busy route does not slow the
+// others down.
+func (l *rateLimiter) observe(res *http.Response) {
+	remaining, err := strconv.ParseInt(res.Header.Get("X-Ratelimit-Global-Remaining"), 10, 64)
+	if err != nil {
+		return
+	}
+	resetMillis, err := strconv.ParseInt(res.Header.Get("X-Ratelimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+	reset := time.Unix(0, resetMillis*int64(time.Millisecond))
+	window := time.Until(reset)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limit := l.limit
+	switch {
+	case window <= 0:
+	case remaining <= 0:
+		l.pausedUntil = reset
+	default:
+		if adaptive := rate.Limit(float64(remaining) / window.Seconds()); adaptive < limit {
+			limit = adaptive
+		}
+	}
+	l.shared.SetLimit(limit)
+	l.low.SetLimit(limit / 2)
+}
+
+// rateLimitedTransport sends every request through a rateLimiter.
+type rateLimitedTransport struct {
+	base    *http.Transport
+	limiter *rateLimiter
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.wait(req.Context()); err != nil {
+		return nil, err
+	}
+	res, err := t.base.RoundTrip(req)
+	if err == nil {
+		t.limiter.observe(res)
+	}
+	return res, err
+}
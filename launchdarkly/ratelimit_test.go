@@ -0,0 +1,88 @@
+package launchdarkly
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func rateLimitResponse(remaining int, reset time.Time) *http.Response {
+	res := &http.Response{Header: http.Header{}}
+	res.Header.Set("X-Ratelimit-Global-Remaining", strconv.Itoa(remaining))
+	res.Header.Set("X-Ratelimit-Reset", strconv.FormatInt(reset.UnixNano()/int64(time.Millisecond), 10))
+	return res
+}
+
+func TestRateLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("adapts to the remaining budget", func(t *testing.T) {
+		l := newRateLimiter(&launchdarklyConfig{RateLimit: 100})
+		l.observe(rateLimitResponse(5, time.Now().Add(10*time.Second)))
+		if limit := l.shared.Limit(); limit > 0.6 || limit < 0.4 {
+			t.Fatalf("expected the limit to drop to about 0.5, got %v", limit)
+		}
+		if limit := l.low.Limit(); limit > 0.3 {
+			t.Fatalf("expected low priority calls to get half the limit, got %v", limit)
+		}
+
+		l.observe(rateLimitResponse(1000, time.Now().Add(time.Second)))
+		if limit := l.shared.Limit(); limit != rate.Limit(100) {
+			t.Fatalf("expected the configured limit to be restored, got %v", limit)
+		}
+	})
+
+	t.Run("pauses until the window resets", func(t *testing.T) {
+		l := newRateLimiter(&launchdarklyConfig{RateLimit: 100})
+		l.observe(rateLimitResponse(0, time.Now().Add(100*time.Millisecond)))
+
+		start := time.Now()
+		if err := l.wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if time.Since(start) < 50*time.Millisecond {
+			t.Fatal("expected the call to wait for the rate limit window to reset")
+		}
+	})
+
+	t.Run("low priority calls queue", func(t *testing.T) {
+		l := newRateLimiter(&launchdarklyConfig{})
+		l.lowQ <- struct{}{}
+
+		cancelled, cancel := context.WithTimeout(withLowPriority(ctx), 20*time.Millisecond)
+		defer cancel()
+		if err := l.wait(cancelled); err == nil {
+			t.Fatal("expected the low priority call to wait for the queue")
+		}
+		if err := l.wait(ctx); err != nil {
+			t.Fatalf("expected interactive calls to bypass the queue: %v", err)
+		}
+	})
+
+	t.Run("shared by the clients of a connection", func(t *testing.T) {
+		b := Backend(nil)
+		limiter := func(connection string, config *launchdarklyConfig) *rateLimiter {
+			t.Helper()
+			client, err := b.newConnectionClient(connection, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return client.http.Transport.(*rateLimitedTransport).limiter
+		}
+
+		shared := limiter("sandbox", &launchdarklyConfig{AccessToken: "api-1", RateLimit: 10})
+		if limiter("sandbox", &launchdarklyConfig{AccessToken: "api-2", RateLimit: 10}) != shared {
+			t.Fatal("expected a new token to keep the rate limiter of the connection")
+		}
+		if limiter("default", &launchdarklyConfig{RateLimit: 10}) == shared {
+			t.Fatal("expected each connection to have its own rate limiter")
+		}
+		if limiter("sandbox", &launchdarklyConfig{RateLimit: 20}) == shared {
+			t.Fatal("expected a new rate_limit to replace the rate limiter")
+		}
+	})
+}
@@ -0,0 +1,297 @@
+package launchdarkly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	ldapi "github.com/launchdarkly/api-client-go"
+)
+
+// defaultReconcileGracePeriod leaves alone the credentials that may still be
+// in the middle of being issued or rolled back.
+const defaultReconcileGracePeriod = 15 * time.Minute
+
+// reconcileSchedule remembers when each connection was last reconciled by the
+// periodic function.
+type reconcileSchedule struct {
+	sync.Mutex
+	last map[string]time.Time
+}
+
+// reconcileItem is a credential found by a reconciliation.
+type reconcileItem struct {
+	ID             string
+	Name           string
+	CredentialType string
+	Date           time.Time
+}
+
+func (item reconcileItem) data() map[string]interface{} {
+	return map[string]interface{}{
+		"id":              item.ID,
+		"name":            item.Name,
+		"credential_type": item.CredentialType,
+		"date":            item.Date.Format(time.RFC3339),
+	}
+}
+
+// reconcileReport compares LaunchDarkly with the plugin's records. Orphaned
+// credentials carry the owner prefix of the connection but have no record,
+// missing ones have a record but no longer exist in LaunchDarkly.
+type reconcileReport struct {
+	Orphaned []reconcileItem
+	Missing  []reconcileItem
+	Deleted  []string
+	Removed  []string
+	Errors   []string
+}
+
+// reconcile lists the access tokens and relay proxy configs of a connection and
+// compares them with the issued records. Unless dryRun is set, orphaned
+// credentials are deleted from LaunchDarkly and the records of missing ones are
+// removed. Anything younger than grace is left alone.
+func (b *backend) reconcile(ctx context.Context, s logical.Storage, connection string, grace time.Duration, dryRun bool) (*reconcileReport, error) {
+	config, err := b.config(ctx, s, connection)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, fmt.Errorf("connection %q is not configured", connection)
+	}
+	if config.OwnerPrefix == "" {
+		return nil, fmt.Errorf("set owner_prefix on connection %q to reconcile it", connection)
+	}
+
+	client, err := b.client(ctx, s, connection)
+	if err != nil {
+		return nil, err
+	}
+
+	// Connections to the same account may share an owner_prefix, so the
+	// records of every connection count as known.
+	issued, err := b.issuedRecords(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	queued, err := s.List(ctx, revokeQueuePrefix)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(issued)+len(queued))
+	for id := range issued {
+		known[id] = true
+	}
+	for _, id := range queued {
+		known[id] = true
+	}
+
+	found, err := listCredentials(ctx, client, config)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &reconcileReport{}
+	cutoff := time.Now().Add(-grace)
+	exists := make(map[string]bool, len(found))
+	for _, item := range found {
+		exists[item.ID] = true
+		if known[item.ID] || !strings.HasPrefix(item.Name, config.OwnerPrefix) || item.Date.After(cutoff) {
+			continue
+		}
+		report.Orphaned = append(report.Orphaned, item)
+	}
+	for id, entry := range issued {
+		if entry.Connection != connection || exists[id] || entry.IssuedAt.After(cutoff) {
+			continue
+		}
+		report.Missing = append(report.Missing, reconcileItem{ID: id, Name: entry.Name, CredentialType: entry.CredentialType, Date: entry.IssuedAt})
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	for _, item := range report.Orphaned {
+		var err error
+		switch item.CredentialType {
+		case "api":
+			_, err = DeleteRoleToken(ctx, client, item.ID)
+		case "rac":
+			err = DeleteRelayToken(ctx, client, item.ID)
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", item.ID, err))
+			continue
+		}
+		report.Deleted = append(report.Deleted, item.ID)
+	}
+	for _, item := range report.Missing {
+		if err := s.Delete(ctx, issuedPrefix+item.ID); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", item.ID, err))
+			continue
+		}
+		report.Removed = append(report.Removed, item.ID)
+	}
+
+	return report, nil
+}
+
+// listCredentials lists the access tokens and relay proxy configs of a
+// connection, except for its own access token. It fails if that token cannot
+// be identified, so that it is never mistaken for an issued credential.
+func listCredentials(ctx context.Context, client *Client, config *launchdarklyConfig) ([]reconcileItem, error) {
+	ownID, err := ownTokenID(ctx, client, config)
+	if err != nil {
+		return nil, fmt.Errorf("could not identify the access token of the connection: %v", err)
+	}
+
+	tokensRaw, _, err := handleRateLimit(ctx, true, true, func() (interface{}, *http.Response, error) {
+		return client.ld.AccessTokensApi.GetTokens(client.authContext(ctx), nil)
+	})
+	if err != nil {
+		return nil, handleLdapiErr(err)
+	}
+	configsRaw, _, err := handleRateLimit(ctx, true, true, func() (interface{}, *http.Response, error) {
+		return client.ld.RelayProxyConfigurationsApi.GetRelayProxyConfigs(client.authContext(ctx))
+	})
+	if err != nil {
+		return nil, handleLdapiErr(err)
+	}
+
+	var found []reconcileItem
+	for _, token := range tokensRaw.(ldapi.Tokens).Items {
+		if ownID != "" && token.Id == ownID {
+			continue
+		}
+		found = append(found, reconcileItem{ID: token.Id, Name: token.Name, CredentialType: "api", Date: millisToTime(token.CreationDate)})
+	}
+	for _, relayConfig := range configsRaw.(ldapi.RelayProxyConfigs).Items {
+		found = append(found, reconcileItem{ID: relayConfig.Id, Name: relayConfig.Name, CredentialType: "rac", Date: millisToTime(relayConfig.CreationDate)})
+	}
+	return found, nil
+}
+
+// ownTokenID returns the id of the access token the connection authenticates
+// with. The stored access_token_id is checked against LaunchDarkly, and looked
+// up from the token when it is not set. OAuth tokens, whether obtained with
+// client credentials or configured as bearer tokens, are not access tokens, so
+// there is none to return for them.
+func ownTokenID(ctx context.Context, client *Client, config *launchdarklyConfig) (string, error) {
+	if config.authType() == authTypeOAuth {
+		return "", nil
+	}
+	token, err := accessTokenMetadata(ctx, client, config)
+	if err != nil {
+		return "", err
+	}
+	if token.Id == "" {
+		return "", errors.New("LaunchDarkly returned no id for the access token")
+	}
+	return token.Id, nil
+}
+
+// reconcileIfDue reconciles, and cleans up, every connection whose
+// reconcile_interval has elapsed.
+func (b *backend) reconcileIfDue(ctx context.Context, s logical.Storage) error {
+	connections, err := b.connections(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for _, connection := range connections {
+		config, err := b.config(ctx, s, connection)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if config == nil || config.OwnerPrefix == "" || config.ReconcileInterval <= 0 {
+			continue
+		}
+
+		b.reconcileSchedule.Lock()
+		last := b.reconcileSchedule.last[connection]
+		b.reconcileSchedule.Unlock()
+		if time.Since(last) < config.ReconcileInterval {
+			continue
+		}
+
+		report, err := b.reconcile(ctx, s, connection, defaultReconcileGracePeriod, false)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", connection, err))
+			continue
+		}
+
+		b.reconcileSchedule.Lock()
+		b.reconcileSchedule.last[connection] = time.Now()
+		b.reconcileSchedule.Unlock()
+
+		if len(report.Deleted) > 0 || len(report.Removed) > 0 {
+			b.Logger().Info("reconciled LaunchDarkly credentials", "connection", connection, "deleted", report.Deleted, "removed_records", report.Removed)
+		}
+		for _, e := range report.Errors {
+			errs = append(errs, fmt.Sprintf("%s: %s", connection, e))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("could not reconcile: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (b *backend) pathReconcile(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if err := validateFields(req, data); err != nil {
+		return nil, logical.CodedError(422, err.Error())
+	}
+
+	grace := time.Duration(data.Get("grace_period").(int)) * time.Second
+	if grace < 0 {
+		return logical.ErrorResponse("grace_period cannot be negative"), nil
+	}
+	dryRun := data.Get("dry_run").(bool)
+
+	report, err := b.reconcile(ctx, req.Storage, connectionName(data), grace, dryRun)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"dry_run":         dryRun,
+			"orphaned":        reconcileItemsData(report.Orphaned),
+			"missing":         reconcileItemsData(report.Missing),
+			"deleted":         emptyIfNil(report.Deleted),
+			"removed_records": emptyIfNil(report.Removed),
+		},
+	}
+	for _, e := range report.Errors {
+		resp.AddWarning(e)
+	}
+	return resp, nil
+}
+
+func reconcileItemsData(items []reconcileItem) []map[string]interface{} {
+	data := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		data = append(data, item.data())
+	}
+	return data
+}
+
+func millisToTime(millis int64) time.Time {
+	return time.Unix(0, millis*int64(time.Millisecond))
+}
+
+// ownedName tags the name of a credential with the owner prefix of the
+// connection, so that reconcile can tell which credentials belong to the mount.
+func (c *Client) ownedName(name string) string {
+	return c.config.OwnerPrefix + name
+}
@@ -0,0 +1,161 @@
+package launchdarkly
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	ldapi "github.com/launchdarkly/api-client-go"
+)
+
+func TestReconcile(t *testing.T) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	old := now - int64(time.Hour/time.Millisecond)
+
+	var createdName string
+	var deleted []string
+	server := newFakeLD(t, map[string]http.HandlerFunc{
+		"/api/v2/tokens": func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				var body ldapi.TokenBody
+				decodeJSON(t, r, &body)
+				createdName = body.Name
+				writeJSON(w, http.StatusCreated, ldapi.Token{Id: "issued", Name: body.Name, CreationDate: old})
+				return
+			}
+			writeJSON(w, http.StatusOK, ldapi.Tokens{Items: []ldapi.Token{
+				{Id: "root", Name: "vault-root", CreationDate: old},
+				{Id: "issued", Name: "vault-vault-generated", CreationDate: old},
+				{Id: "orphan", Name: "vault-vault-generated", CreationDate: old},
+				{Id: "recent", Name: "vault-vault-generated", CreationDate: now},
+				{Id: "foreign", Name: "ci", CreationDate: old},
+				{Id: "other-connection", Name: "vault-vault-generated", CreationDate: old},
+			}})
+		},
+		"/api/v2/account/relay-auto-configs": func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, ldapi.RelayProxyConfigs{Items: []ldapi.RelayProxyConfig{
+				{Id: "relay-orphan", Name: "vault-relay", CreationDate: old},
+			}})
+		},
+		"/api/v2/tokens/": func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				if r.URL.Path != "/api/v2/tokens/root" {
+					writeJSON(w, http.StatusNotFound, nil)
+					return
+				}
+				writeJSON(w, http.StatusOK, ldapi.Token{Id: "root", Name: "vault-root", CreationDate: old})
+				return
+			}
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/api/v2/tokens/"))
+			w.WriteHeader(http.StatusNoContent)
+		},
+		"/api/v2/account/relay-auto-configs/": func(w http.ResponseWriter, r *http.Request) {
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/api/v2/account/relay-auto-configs/"))
+			w.WriteHeader(http.StatusNoContent)
+		},
+	})
+
+	e, err := newTestAccEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := e.Backend.(*backend)
+	ids := func(items interface{}) []string {
+		var ids []string
+		for _, item := range items.([]map[string]interface{}) {
+			ids = append(ids, item["id"].(string))
+		}
+		sort.Strings(ids)
+		return strings.Split(strings.Join(ids, ","), ",")
+	}
+
+	e.mustRequest(t, logical.UpdateOperation, "config", map[string]interface{}{
+		"access_token":    "api-1234",
+		"access_token_id": "root",
+		"base_uri":        server.URL,
+		"skip_verify":     true,
+	})
+	if resp, _ := e.request(logical.UpdateOperation, "reconcile", nil); resp == nil || !resp.IsError() {
+		t.Fatal("expected reconcile to require owner_prefix")
+	}
+	e.mustRequest(t, logical.UpdateOperation, "config", map[string]interface{}{
+		"owner_prefix": "vault-",
+	})
+
+	e.mustRequest(t, logical.UpdateOperation, "role/"+roleTestName, map[string]interface{}{
+		"custom_role_ids": []string{"reader"},
+	})
+	e.mustRequest(t, logical.ReadOperation, "creds/"+roleTestName, nil)
+	if !strings.HasPrefix(createdName, "vault-vault-generated-") {
+		t.Fatalf("expected the token name to carry the owner prefix, got %q", createdName)
+	}
+
+	if err := b.putIssued(e.Context, e.Storage, &issuedEntry{
+		ID:             "other-connection",
+		CredentialType: "api",
+		Connection:     "other",
+		IssuedAt:       time.Now().Add(-time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.putIssued(e.Context, e.Storage, &issuedEntry{
+		ID:             "ghost",
+		CredentialType: "api",
+		Connection:     defaultConnection,
+		IssuedAt:       time.Now().Add(-time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := e.mustRequest(t, logical.UpdateOperation, "reconcile", nil)
+	if got := ids(resp.Data["orphaned"]); strings.Join(got, ",") != "orphan,relay-orphan" {
+		t.Fatalf("unexpected orphaned credentials: %v", got)
+	}
+	if got := ids(resp.Data["missing"]); strings.Join(got, ",") != "ghost" {
+		t.Fatalf("unexpected missing credentials: %v", got)
+	}
+	if resp.Data["dry_run"] != true || len(deleted) != 0 {
+		t.Fatalf("expected a dry run by default, deleted: %v", deleted)
+	}
+
+	resp = e.mustRequest(t, logical.UpdateOperation, "reconcile", map[string]interface{}{
+		"dry_run": false,
+	})
+	sort.Strings(deleted)
+	if strings.Join(deleted, ",") != "orphan,relay-orphan" {
+		t.Fatalf("unexpected deletions: %v", deleted)
+	}
+	if removed := resp.Data["removed_records"].([]string); len(removed) != 1 || removed[0] != "ghost" {
+		t.Fatalf("unexpected removed records: %v", removed)
+	}
+	if entry, err := b.issued(e.Context, e.Storage, "issued"); err != nil || entry == nil {
+		t.Fatalf("expected the issued record to be kept, got %#v, %v", entry, err)
+	}
+
+	// An OAuth bearer token is not an access token, so there is none to leave
+	// out of the listing.
+	e.mustRequest(t, logical.UpdateOperation, "config/bearer", map[string]interface{}{
+		"auth_type":    "oauth",
+		"access_token": "oauth-1234",
+		"base_uri":     server.URL,
+		"skip_verify":  true,
+		"owner_prefix": "vault-",
+	})
+	resp = e.mustRequest(t, logical.UpdateOperation, "reconcile", map[string]interface{}{"connection": "bearer"})
+	if got := ids(resp.Data["orphaned"]); strings.Join(got, ",") != "orphan,relay-orphan,root" {
+		t.Fatalf("unexpected orphaned credentials of the bearer connection: %v", got)
+	}
+
+	// Without a known access token id, the token cannot be told apart from
+	// the credentials it issued.
+	e.mustRequest(t, logical.UpdateOperation, "config", map[string]interface{}{
+		"access_token_id": "",
+		"skip_verify":     true,
+	})
+	if resp, _ := e.request(logical.UpdateOperation, "reconcile", nil); resp == nil || !resp.IsError() {
+		t.Fatalf("expected reconcile to refuse an unidentified access token, got %#v", resp)
+	}
+}
@@ -0,0 +1,220 @@
+package launchdarkly
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// revokeAllFilter selects the credentials deleted by revoke-all. Zero values
+// match everything.
+type revokeAllFilter struct {
+	Connection    string
+	SecretType    string
+	Role          string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+func (f *revokeAllFilter) matchesTime(created time.Time) bool {
+	if !f.CreatedAfter.IsZero() && created.Before(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !created.Before(f.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+func (f *revokeAllFilter) matchesIssued(entry *issuedEntry) bool {
+	if f.Connection != "" && entry.Connection != f.Connection {
+		return false
+	}
+	if f.SecretType != "" && entry.SecretType != f.SecretType {
+		return false
+	}
+	if f.Role != "" && entry.Role != f.Role {
+		return false
+	}
+	return f.matchesTime(entry.IssuedAt)
+}
+
+// matchesUnrecorded reports whether a credential found in LaunchDarkly without
+// an issued record matches. Only its type and creation date are known, so it
+// never matches a role filter. Role and coderefs tokens are both access tokens
+// and cannot be told apart, so only relay proxy configs match a secret_type.
+func (f *revokeAllFilter) matchesUnrecorded(item reconcileItem) bool {
+	if f.Role != "" {
+		return false
+	}
+	switch f.SecretType {
+	case "":
+	case "relay":
+		if item.CredentialType != "rac" {
+			return false
+		}
+	default:
+		return false
+	}
+	return f.matchesTime(item.Date)
+}
+
+// revokeAll deletes every credential the mount has issued that matches the
+// filter. The issued records are completed by the credentials named with the
+// owner_prefix of each connection, so that nothing is missed when records or
+// leases are out of date. Deletions that fail are queued for retry. A
+// connection whose credentials cannot be listed is reported in failed as
+// connection:<name>, with its error in warnings, and its recorded credentials
+// are still deleted.
+func (b *backend) revokeAll(ctx context.Context, s logical.Storage, filter *revokeAllFilter) (deleted, failed, warnings []string, err error) {
+	targets := make(map[string]*revokeQueueEntry)
+
+	ids, err := s.List(ctx, issuedPrefix)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, id := range ids {
+		entry, err := b.issued(ctx, s, id)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if entry != nil && filter.matchesIssued(entry) {
+			targets[id] = &revokeQueueEntry{ID: id, CredentialType: entry.CredentialType, Connection: entry.Connection}
+		}
+	}
+
+	connections, err := b.connections(ctx, s)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, connection := range connections {
+		if filter.Connection != "" && connection != filter.Connection {
+			continue
+		}
+		items, err := b.ownedCredentials(ctx, s, connection)
+		if err != nil {
+			b.Logger().Error("revoke-all could not list the credentials of a connection", "connection", connection, "error", err)
+			failed = append(failed, "connection:"+connection)
+			warnings = append(warnings, fmt.Sprintf("could not list the credentials of connection %q, only its recorded credentials were deleted: %v", connection, err))
+			continue
+		}
+		for _, item := range items {
+			if _, ok := targets[item.ID]; ok {
+				continue
+			}
+			if recorded, err := b.issued(ctx, s, item.ID); err != nil {
+				return nil, nil, nil, err
+			} else if recorded != nil {
+				// Its record did not match the filter.
+				continue
+			}
+			if filter.matchesUnrecorded(item) {
+				targets[item.ID] = &revokeQueueEntry{ID: item.ID, CredentialType: item.CredentialType, Connection: connection}
+			}
+		}
+	}
+
+	queued := 0
+	for _, id := range sortedKeys(targets) {
+		entry := targets[id]
+		if err := b.revoke(ctx, s, entry); err != nil {
+			b.Logger().Error("revoke-all could not delete a credential, queued for retry", "id", id, "connection", entry.Connection, "error", err)
+			if queueErr := b.queueRevocation(ctx, s, entry, err); queueErr != nil {
+				return deleted, failed, warnings, queueErr
+			}
+			failed = append(failed, id)
+			queued++
+			continue
+		}
+		b.Logger().Info("revoke-all deleted a credential", "id", id, "credential_type", entry.CredentialType, "connection", entry.Connection)
+		deleted = append(deleted, id)
+	}
+
+	if queued > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d credentials could not be deleted and were queued for retry, see revoke-queue/", queued))
+	}
+	return deleted, failed, warnings, nil
+}
+
+// ownedCredentials lists the access tokens and relay proxy configs of a
+// connection that carry its owner_prefix. It returns nothing for connections
+// without an owner_prefix.
+func (b *backend) ownedCredentials(ctx context.Context, s logical.Storage, connection string) ([]reconcileItem, error) {
+	config, err := b.config(ctx, s, connection)
+	if err != nil || config == nil || config.OwnerPrefix == "" {
+		return nil, err
+	}
+	client, err := b.client(ctx, s, connection)
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := listCredentials(ctx, client, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []reconcileItem
+	for _, item := range found {
+		if strings.HasPrefix(item.Name, config.OwnerPrefix) {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func sortedKeys(m map[string]*revokeQueueEntry) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (b *backend) pathRevokeAll(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if err := validateFields(req, data); err != nil {
+		return nil, logical.CodedError(422, err.Error())
+	}
+
+	filter := &revokeAllFilter{
+		Connection: data.Get("connection").(string),
+		SecretType: data.Get("secret_type").(string),
+		Role:       data.Get("role").(string),
+	}
+	switch filter.SecretType {
+	case "", "role", "coderefs", "relay":
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("secret_type must be one of role, coderefs or relay, got %q", filter.SecretType)), nil
+	}
+	for field, dest := range map[string]*time.Time{
+		"created_after":  &filter.CreatedAfter,
+		"created_before": &filter.CreatedBefore,
+	} {
+		if v := data.Get(field).(string); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("%s must be an RFC 3339 time: %v", field, err)), nil
+			}
+			*dest = t
+		}
+	}
+
+	deleted, failed, warnings, err := b.revokeAll(ctx, req.Storage, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"deleted": emptyIfNil(deleted),
+			"failed":  emptyIfNil(failed),
+		},
+		Warnings: warnings,
+	}, nil
+}
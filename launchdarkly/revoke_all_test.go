@@ -0,0 +1,183 @@
+package launchdarkly
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	ldapi "github.com/launchdarkly/api-client-go"
+)
+
+func TestRevokeAll(t *testing.T) {
+	defer func(d time.Duration) { retryBaseDelay = d }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	old := time.Now().Add(-2*time.Hour).UnixNano() / int64(time.Millisecond)
+	tokens := map[string]string{
+		"root":    "vault-root",
+		"role-a":  "vault-a",
+		"role-b":  "vault-b",
+		"orphan":  "vault-orphan",
+		"stuck":   "vault-stuck",
+		"foreign": "ci",
+	}
+	configs := map[string]string{
+		"relay-1":      "vault-relay",
+		"relay-orphan": "vault-relay",
+	}
+	var deleted []string
+	server := newFakeLD(t, map[string]http.HandlerFunc{
+		"/api/v2/tokens": func(w http.ResponseWriter, r *http.Request) {
+			var items []ldapi.Token
+			for id, name := range tokens {
+				items = append(items, ldapi.Token{Id: id, Name: name, CreationDate: old})
+			}
+			writeJSON(w, http.StatusOK, ldapi.Tokens{Items: items})
+		},
+		"/api/v2/account/relay-auto-configs": func(w http.ResponseWriter, r *http.Request) {
+			var items []ldapi.RelayProxyConfig
+			for id, name := range configs {
+				items = append(items, ldapi.RelayProxyConfig{Id: id, Name: name, CreationDate: old})
+			}
+			writeJSON(w, http.StatusOK, ldapi.RelayProxyConfigs{Items: items})
+		},
+		"/api/v2/tokens/": func(w http.ResponseWriter, r *http.Request) {
+			id := strings.TrimPrefix(r.URL.Path, "/api/v2/tokens/")
+			if r.Method == http.MethodGet {
+				writeJSON(w, http.StatusOK, ldapi.Token{Id: id, Name: tokens[id], CreationDate: old})
+				return
+			}
+			if id == "stuck" {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			delete(tokens, id)
+			deleted = append(deleted, id)
+			w.WriteHeader(http.StatusNoContent)
+		},
+		"/api/v2/account/relay-auto-configs/": func(w http.ResponseWriter, r *http.Request) {
+			id := strings.TrimPrefix(r.URL.Path, "/api/v2/account/relay-auto-configs/")
+			delete(configs, id)
+			deleted = append(deleted, id)
+			w.WriteHeader(http.StatusNoContent)
+		},
+	})
+
+	e, err := newTestAccEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := e.Backend.(*backend)
+	revokeAll := func(data map[string]interface{}) (string, string) {
+		t.Helper()
+		deleted = nil
+		resp := e.mustRequest(t, logical.UpdateOperation, "revoke-all", data)
+		sort.Strings(deleted)
+		if got := strings.Join(resp.Data["deleted"].([]string), ","); got != strings.Join(deleted, ",") {
+			t.Fatalf("expected the summary %q to match the deletions %v", got, deleted)
+		}
+		return strings.Join(deleted, ","), strings.Join(resp.Data["failed"].([]string), ",")
+	}
+
+	e.mustRequest(t, logical.UpdateOperation, "config", map[string]interface{}{
+		"access_token":    "api-1234",
+		"access_token_id": "root",
+		"base_uri":        server.URL,
+		"skip_verify":     true,
+		"owner_prefix":    "vault-",
+		"rate_limit":      100,
+	})
+	for _, entry := range []*issuedEntry{
+		{ID: "role-a", CredentialType: "api", SecretType: "role", Role: "a"},
+		{ID: "role-b", CredentialType: "api", SecretType: "role", Role: "b"},
+		{ID: "relay-1", CredentialType: "rac", SecretType: "relay", Policy: "relay"},
+	} {
+		entry.Connection = defaultConnection
+		entry.IssuedAt = time.Now().Add(-time.Hour)
+		if err := b.putIssued(e.Context, e.Storage, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e.mustRefuse(t, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "revoke-all",
+		Data:      map[string]interface{}{"secret_type": "everything"},
+	})
+
+	// Role names are stored in lower case.
+	if got, _ := revokeAll(map[string]interface{}{"role": "A"}); got != "role-a" {
+		t.Fatalf("unexpected deletions for role A: %v", got)
+	}
+	if got, _ := revokeAll(map[string]interface{}{"created_after": time.Now().Format(time.RFC3339)}); got != "" {
+		t.Fatalf("expected nothing created after now, got %v", got)
+	}
+	if got, _ := revokeAll(map[string]interface{}{"secret_type": "relay"}); got != "relay-1,relay-orphan" {
+		t.Fatalf("unexpected relay deletions: %v", got)
+	}
+	if got, _ := revokeAll(map[string]interface{}{"secret_type": "coderefs"}); got != "" {
+		t.Fatalf("expected unrecorded tokens not to match a coderefs filter, got %v", got)
+	}
+
+	got, failed := revokeAll(nil)
+	if got != "orphan,role-b" || failed != "stuck" {
+		t.Fatalf("unexpected deletions: %v, failures: %v", got, failed)
+	}
+	if _, ok := tokens["foreign"]; !ok {
+		t.Fatal("expected the token without the owner prefix to be kept")
+	}
+	if entry, err := b.revokeQueueEntry(e.Context, e.Storage, "stuck"); err != nil || entry == nil {
+		t.Fatalf("expected the failed deletion to be queued, got %#v, %v", entry, err)
+	}
+	if ids, _ := e.Storage.List(e.Context, issuedPrefix); len(ids) != 0 {
+		t.Fatalf("expected the issued records to be removed, got %v", ids)
+	}
+
+	// A connection whose credentials cannot be listed is reported, and its
+	// recorded credentials are still deleted.
+	broken := newFakeLD(t, map[string]http.HandlerFunc{
+		"/api/v2/tokens": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		},
+		"/api/v2/tokens/": func(w http.ResponseWriter, r *http.Request) {
+			id := strings.TrimPrefix(r.URL.Path, "/api/v2/tokens/")
+			if r.Method == http.MethodGet {
+				writeJSON(w, http.StatusOK, ldapi.Token{Id: id, Name: "vault-root", CreationDate: old})
+				return
+			}
+			deleted = append(deleted, id)
+			w.WriteHeader(http.StatusNoContent)
+		},
+	})
+	e.mustRequest(t, logical.UpdateOperation, "config/broken", map[string]interface{}{
+		"access_token":    "api-5678",
+		"access_token_id": "broken-root",
+		"base_uri":        broken.URL,
+		"skip_verify":     true,
+		"owner_prefix":    "vault-",
+	})
+	if err := b.putIssued(e.Context, e.Storage, &issuedEntry{
+		ID:             "broken-1",
+		CredentialType: "api",
+		SecretType:     "role",
+		Role:           "a",
+		Connection:     "broken",
+		IssuedAt:       time.Now().Add(-time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	deleted = nil
+	resp := e.mustRequest(t, logical.UpdateOperation, "revoke-all", map[string]interface{}{"connection": "broken"})
+	if got := strings.Join(resp.Data["deleted"].([]string), ","); got != "broken-1" || strings.Join(deleted, ",") != "broken-1" {
+		t.Fatalf("expected the recorded credential of the broken connection to be deleted, got %v", got)
+	}
+	if got := strings.Join(resp.Data["failed"].([]string), ","); got != "connection:broken" {
+		t.Fatalf("expected the broken connection to be reported, got %v", got)
+	}
+	if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], `connection "broken"`) {
+		t.Fatalf("expected a warning about the broken connection, got %v", resp.Warnings)
+	}
+}
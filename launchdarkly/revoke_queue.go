@@ -0,0 +1,244 @@
+package launchdarkly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	revokeQueuePrefix = "revoke-queue/"
+
+	// revokeQueueMinBackoff and revokeQueueMaxBackoff bound the wait between two
+	// attempts at a queued revocation.
+	revokeQueueMinBackoff = time.Minute
+	revokeQueueMaxBackoff = time.Hour
+
+	// revokeFlushTimeout bounds the last attempt made when the backend shuts
+	// down, so that sealing or unmounting does not wait on LaunchDarkly.
+	revokeFlushTimeout = 5 * time.Second
+)
+
+// revokeQueueEntry is a LaunchDarkly credential whose lease was revoked while
+// LaunchDarkly could not delete it. It is retried until the deletion succeeds.
+type revokeQueueEntry struct {
+	ID             string    `json:"id"`
+	CredentialType string    `json:"credential_type"`
+	Connection     string    `json:"connection"`
+	Attempts       int       `json:"attempts"`
+	LastError      string    `json:"last_error"`
+	QueuedAt       time.Time `json:"queued_at"`
+	NextAttempt    time.Time `json:"next_attempt"`
+}
+
+// revokeQueue serializes the processing of the queue between the periodic
+// function, the retry endpoint and shutdown.
+type revokeQueue struct {
+	sync.Mutex
+}
+
+// revoke deletes the LaunchDarkly credential of the entry and its issued record.
+// Credentials that are already gone count as revoked.
+func (b *backend) revoke(ctx context.Context, s logical.Storage, entry *revokeQueueEntry) error {
+	client, err := b.client(ctx, s, entry.Connection)
+	if err != nil {
+		return err
+	}
+
+	switch entry.CredentialType {
+	case "api":
+		_, err = DeleteRoleToken(ctx, client, entry.ID)
+	case "rac":
+		err = DeleteRelayToken(ctx, client, entry.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.Delete(ctx, issuedPrefix+entry.ID)
+}
+
+// queueRevocation stores a revocation that failed with err so that it is
+// retried later.
+func (b *backend) queueRevocation(ctx context.Context, s logical.Storage, entry *revokeQueueEntry, err error) error {
+	now := time.Now()
+	if entry.QueuedAt.IsZero() {
+		entry.QueuedAt = now
+	}
+	entry.Attempts++
+	entry.LastError = err.Error()
+	entry.NextAttempt = now.Add(revokeQueueBackoff(entry.Attempts))
+
+	storageEntry, err := logical.StorageEntryJSON(revokeQueuePrefix+entry.ID, entry)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, storageEntry)
+}
+
+func revokeQueueBackoff(attempts int) time.Duration {
+	backoff := revokeQueueMinBackoff << uint(attempts-1)
+	if backoff <= 0 || backoff > revokeQueueMaxBackoff {
+		backoff = revokeQueueMaxBackoff
+	}
+	return backoff
+}
+
+func (b *backend) revokeQueueEntry(ctx context.Context, s logical.Storage, id string) (*revokeQueueEntry, error) {
+	storageEntry, err := s.Get(ctx, revokeQueuePrefix+id)
+	if err != nil {
+		return nil, err
+	}
+	if storageEntry == nil {
+		return nil, nil
+	}
+
+	entry := &revokeQueueEntry{}
+	if err := storageEntry.DecodeJSON(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// processRevokeQueue retries the queued revocations that are due, or all of
+// them when force is set. It returns the ids that were revoked and those that
+// failed again.
+func (b *backend) processRevokeQueue(ctx context.Context, s logical.Storage, force bool) (revoked, failed []string, err error) {
+	b.revokeQueue.Lock()
+	defer b.revokeQueue.Unlock()
+
+	ids, err := s.List(ctx, revokeQueuePrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			break
+		}
+
+		entry, err := b.revokeQueueEntry(ctx, s, id)
+		if err != nil {
+			return revoked, failed, err
+		}
+		if entry == nil || (!force && now.Before(entry.NextAttempt)) {
+			continue
+		}
+
+		if revokeErr := b.revoke(ctx, s, entry); revokeErr != nil {
+			b.Logger().Warn("queued revocation failed", "id", entry.ID, "connection", entry.Connection, "attempts", entry.Attempts+1, "error", revokeErr)
+			if err := b.queueRevocation(ctx, s, entry, revokeErr); err != nil {
+				return revoked, failed, err
+			}
+			failed = append(failed, id)
+			continue
+		}
+
+		if err := s.Delete(ctx, revokeQueuePrefix+id); err != nil {
+			return revoked, failed, err
+		}
+		revoked = append(revoked, id)
+	}
+
+	return revoked, failed, nil
+}
+
+// flushRevokeQueue makes a last attempt at every queued revocation before the
+// backend shuts down. Only the active node of the primary cluster does, since
+// the storage of standbys and secondaries cannot be written.
+func (b *backend) flushRevokeQueue() {
+	if b.storage == nil || !b.canWriteStorage() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), revokeFlushTimeout)
+	defer cancel()
+
+	if _, _, err := b.processRevokeQueue(ctx, b.storage, true); err != nil {
+		b.Logger().Warn("could not flush the revocation queue", "error", err)
+	}
+}
+
+// canWriteStorage reports whether this node can write the storage of the
+// mount, which performance standbys and replication secondaries cannot.
+func (b *backend) canWriteStorage() bool {
+	sys := b.System()
+	if sys == nil {
+		return false
+	}
+	state := sys.ReplicationState()
+	if state.HasState(consts.ReplicationPerformanceStandby | consts.ReplicationDRSecondary) {
+		return false
+	}
+	return sys.LocalMount() || !state.HasState(consts.ReplicationPerformanceSecondary)
+}
+
+func (b *backend) pathRevokeQueueList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return listStorage(ctx, req.Storage, revokeQueuePrefix, data)
+}
+
+func (b *backend) pathRevokeQueueRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entry, err := b.revokeQueueEntry(ctx, req.Storage, data.Get("id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"id":              entry.ID,
+			"credential_type": entry.CredentialType,
+			"connection":      entry.Connection,
+			"attempts":        entry.Attempts,
+			"last_error":      entry.LastError,
+			"queued_at":       entry.QueuedAt.Format(time.RFC3339),
+			"next_attempt":    entry.NextAttempt.Format(time.RFC3339),
+		},
+	}, nil
+}
+
+func (b *backend) pathRevokeQueueDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	id := data.Get("id").(string)
+	if id == "" {
+		return nil, errors.New("id is required")
+	}
+
+	if err := req.Storage.Delete(ctx, revokeQueuePrefix+id); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (b *backend) pathRevokeQueueRetry(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	revoked, failed, err := b.processRevokeQueue(ctx, req.Storage, true)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"revoked": emptyIfNil(revoked),
+			"failed":  emptyIfNil(failed),
+		},
+	}
+	if len(failed) > 0 {
+		resp.AddWarning(fmt.Sprintf("%d revocations failed again, read revoke-queue/<id> for the errors", len(failed)))
+	}
+	return resp, nil
+}
+
+func emptyIfNil(ids []string) []string {
+	if ids == nil {
+		return []string{}
+	}
+	return ids
+}
@@ -0,0 +1,129 @@
+package launchdarkly
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestRevokeQueue(t *testing.T) {
+	defer func(base time.Duration) { retryBaseDelay = base }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	deleteStatus := http.StatusServiceUnavailable
+	server := newFakeLD(t, map[string]http.HandlerFunc{
+		"/api/v2/tokens/": func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, deleteStatus, nil)
+		},
+		"/api/v2/account/relay-auto-configs/": func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusNotFound, nil)
+		},
+	})
+
+	e, err := newTestAccEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := e.Backend.(*backend)
+	revoke := func(id, credentialType string) {
+		t.Helper()
+		_, err := e.handle(&logical.Request{
+			Operation: logical.RevokeOperation,
+			Secret: &logical.Secret{
+				InternalData: map[string]interface{}{
+					"secret_type":     programmaticAPIKey,
+					"api_key_id":      id,
+					"credential_type": credentialType,
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("expected the revocation to be queued, got %v", err)
+		}
+	}
+
+	e.mustRequest(t, logical.UpdateOperation, "config", map[string]interface{}{
+		"access_token": "api-1234",
+		"base_uri":     server.URL,
+		"skip_verify":  true,
+	})
+
+	revoke("token1", "api")
+	revoke("relay1", "rac")
+
+	resp := e.mustRequest(t, logical.ListOperation, "revoke-queue/", nil)
+	if keys := resp.Data["keys"].([]string); len(keys) != 1 || keys[0] != "token1" {
+		t.Fatalf("expected only the failed revocation to be queued, got %v", keys)
+	}
+
+	resp = e.mustRequest(t, logical.ReadOperation, "revoke-queue/token1", nil)
+	if resp.Data["attempts"] != 1 || resp.Data["credential_type"] != "api" || resp.Data["last_error"] == "" {
+		t.Fatalf("unexpected queue entry: %#v", resp.Data)
+	}
+
+	// Not due yet.
+	if err := b.periodicFunc(e.Context, &logical.Request{Storage: e.Storage}); err != nil {
+		t.Fatal(err)
+	}
+	resp = e.mustRequest(t, logical.ReadOperation, "revoke-queue/token1", nil)
+	if resp.Data["attempts"] != 1 {
+		t.Fatalf("expected the entry to wait for its backoff, got %#v", resp.Data)
+	}
+
+	resp = e.mustRequest(t, logical.UpdateOperation, "revoke-queue/retry", nil)
+	if failed := resp.Data["failed"].([]string); len(failed) != 1 {
+		t.Fatalf("expected the retry to fail, got %#v", resp.Data)
+	}
+	resp = e.mustRequest(t, logical.ReadOperation, "revoke-queue/token1", nil)
+	if resp.Data["attempts"] != 2 {
+		t.Fatalf("expected the attempt to be recorded, got %#v", resp.Data)
+	}
+
+	// Tokens that are already gone count as revoked.
+	deleteStatus = http.StatusNotFound
+	resp = e.mustRequest(t, logical.UpdateOperation, "revoke-queue/retry", nil)
+	if revoked := resp.Data["revoked"].([]string); len(revoked) != 1 || revoked[0] != "token1" {
+		t.Fatalf("expected the revocation to succeed, got %#v", resp.Data)
+	}
+	if resp := e.mustRequest(t, logical.ReadOperation, "revoke-queue/token1", nil); resp != nil {
+		t.Fatalf("expected the entry to be removed, got %#v", resp.Data)
+	}
+}
+
+func TestFlushRevokeQueue(t *testing.T) {
+	for state, flushed := range map[consts.ReplicationState]bool{
+		consts.ReplicationUnknown:              true,
+		consts.ReplicationPerformanceStandby:   false,
+		consts.ReplicationPerformanceSecondary: false,
+		consts.ReplicationDRSecondary:          false,
+	} {
+		ctx := context.Background()
+		storage := &logical.InmemStorage{}
+		b, err := Factory(ctx, &logical.BackendConfig{
+			System:      &logical.StaticSystemView{ReplicationStateVal: state},
+			StorageView: storage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		lb := b.(*backend)
+		if err := lb.queueRevocation(ctx, storage, &revokeQueueEntry{ID: "stuck", CredentialType: "api", Connection: defaultConnection}, errors.New("unavailable")); err != nil {
+			t.Fatal(err)
+		}
+
+		// Without a configured connection the attempt fails and is counted.
+		b.Cleanup(ctx)
+		entry, err := lb.revokeQueueEntry(ctx, storage, "stuck")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attempted := entry.Attempts > 1; attempted != flushed {
+			t.Fatalf("replication state %d: expected a flush: %v, got %d attempts", state, flushed, entry.Attempts)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package launchdarkly
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	ldapi "github.com/launchdarkly/api-client-go"
+)
+
+const (
+	walTokenKind = "token"
+	walRelayKind = "relay_config"
+
+	// walCreateWindow bounds how long after the WAL entry was written a
+	// credential found by name is considered to come from that create call.
+	walCreateWindow = 5 * time.Minute
+	// walClockSkew allows for the clocks of Vault and LaunchDarkly to disagree.
+	walClockSkew = time.Minute
+)
+
+// walEntry records a LaunchDarkly credential while it is being issued. It is
+// written before the create call and removed once the response is built, so a
+// leftover entry means the credential may exist without a lease to revoke it.
+type walEntry struct {
+	Connection string `json:"connection"`
+	Name       string `json:"name"`
+	// ID is set once LaunchDarkly has returned the created credential.
+	ID string `json:"id,omitempty"`
+	// CreatedAt is in milliseconds, like the LaunchDarkly creation dates.
+	CreatedAt int64 `json:"created_at"`
+}
+
+// notCreatedError is returned by create calls that LaunchDarkly rejected, so
+// that nothing is left to roll back.
+type notCreatedError struct {
+	error
+}
+
+// createErr converts the error of a create call, marking client errors as
+// notCreatedError.
+func createErr(res *http.Response, err error) error {
+	err = handleLdapiErr(err)
+	if res != nil && res.StatusCode >= 400 && res.StatusCode < 500 {
+		return notCreatedError{err}
+	}
+	return err
+}
+
+// putWAL records that a credential named name is about to be created.
+func putWAL(ctx context.Context, s logical.Storage, kind, connection, name string) (string, *walEntry, error) {
+	entry := &walEntry{
+		Connection: connection,
+		Name:       name,
+		CreatedAt:  time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	walID, err := framework.PutWAL(ctx, s, kind, entry)
+	if err != nil {
+		return "", nil, err
+	}
+	return walID, entry, nil
+}
+
+// createFailed removes the WAL entry of a create call that LaunchDarkly
+// rejected. Other failures keep it, as the credential may have been created.
+func (b *backend) createFailed(ctx context.Context, s logical.Storage, walID string, err error) {
+	if _, ok := err.(notCreatedError); !ok {
+		return
+	}
+	if err := framework.DeleteWAL(ctx, s, walID); err != nil {
+		b.Logger().Warn("could not remove the WAL entry of a rejected create call", "wal_id", walID, "error", err)
+	}
+}
+
+// recordCreated replaces the WAL entry with one that holds the id of the
+// created credential, so that a rollback does not need to look it up by name.
+// The original entry is kept if the new one cannot be written.
+func (b *backend) recordCreated(ctx context.Context, s logical.Storage, walID, kind string, entry *walEntry, id string) string {
+	entry.ID = id
+	newID, err := framework.PutWAL(ctx, s, kind, entry)
+	if err != nil {
+		b.Logger().Warn("could not record the id of a created credential", "id", id, "error", err)
+		return walID
+	}
+	if err := framework.DeleteWAL(ctx, s, walID); err != nil {
+		b.Logger().Warn("could not remove a WAL entry", "wal_id", walID, "error", err)
+	}
+	return newID
+}
+
+// walRollback deletes the LaunchDarkly credential of a create call whose
+// response was never returned.
+func (b *backend) walRollback(ctx context.Context, req *logical.Request, kind string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	var entry walEntry
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return err
+	}
+
+	config, err := b.config(ctx, req.Storage, entry.Connection)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		b.Logger().Warn("dropping a WAL entry of a removed connection", "connection", entry.Connection, "name", entry.Name, "id", entry.ID)
+		return nil
+	}
+	client, err := b.client(ctx, req.Storage, entry.Connection)
+	if err != nil {
+		return err
+	}
+
+	var claimed map[string]bool
+	if entry.ID == "" {
+		if claimed, err = b.claimedIDs(ctx, req.Storage); err != nil {
+			return err
+		}
+	}
+
+	switch kind {
+	case walTokenKind:
+		return b.rollbackToken(ctx, client, &entry, claimed)
+	case walRelayKind:
+		return b.rollbackRelayConfig(ctx, client, &entry, claimed)
+	default:
+		b.Logger().Warn("dropping a WAL entry of an unknown kind", "kind", kind)
+		return nil
+	}
+}
+
+// claimedIDs returns the ids of the credentials that have a lease, or that
+// another create call has recorded, so that a rollback by name never picks
+// them.
+func (b *backend) claimedIDs(ctx context.Context, s logical.Storage) (map[string]bool, error) {
+	claimed := make(map[string]bool)
+
+	ids, err := s.List(ctx, issuedPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		claimed[id] = true
+	}
+
+	walIDs, err := framework.ListWAL(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	for _, walID := range walIDs {
+		wal, err := framework.GetWAL(ctx, s, walID)
+		if err != nil {
+			return nil, err
+		}
+		if wal == nil {
+			continue
+		}
+		raw, err := json.Marshal(wal.Data)
+		if err != nil {
+			return nil, err
+		}
+		var entry walEntry
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return nil, err
+		}
+		if entry.ID != "" {
+			claimed[entry.ID] = true
+		}
+	}
+	return claimed, nil
+}
+
+func (b *backend) rollbackToken(ctx context.Context, client *Client, entry *walEntry, claimed map[string]bool) error {
+	id := entry.ID
+	if id == "" {
+		tokensRaw, _, err := handleRateLimit(ctx, true, true, func() (interface{}, *http.Response, error) {
+			return client.ld.AccessTokensApi.GetTokens(client.authContext(ctx), nil)
+		})
+		if err != nil {
+			return handleLdapiErr(err)
+		}
+
+		var matches []string
+		for _, token := range tokensRaw.(ldapi.Tokens).Items {
+			if token.Name == entry.Name && entry.createdBy(token.CreationDate) && !claimed[token.Id] {
+				matches = append(matches, token.Id)
+			}
+		}
+		if id = b.rollbackMatch("access token", entry, matches); id == "" {
+			return nil
+		}
+	}
+
+	b.Logger().Info("rolling back an access token that was never returned", "id", id, "name", entry.Name)
+	_, err := DeleteRoleToken(ctx, client, id)
+	return err
+}
+
+func (b *backend) rollbackRelayConfig(ctx context.Context, client *Client, entry *walEntry, claimed map[string]bool) error {
+	id := entry.ID
+	if id == "" {
+		configsRaw, _, err := handleRateLimit(ctx, true, true, func() (interface{}, *http.Response, error) {
+			return client.ld.RelayProxyConfigurationsApi.GetRelayProxyConfigs(client.authContext(ctx))
+		})
+		if err != nil {
+			return handleLdapiErr(err)
+		}
+
+		var matches []string
+		for _, config := range configsRaw.(ldapi.RelayProxyConfigs).Items {
+			if config.Name == entry.Name && entry.createdBy(config.CreationDate) && !claimed[config.Id] {
+				matches = append(matches, config.Id)
+			}
+		}
+		if id = b.rollbackMatch("relay proxy config", entry, matches); id == "" {
+			return nil
+		}
+	}
+
+	b.Logger().Info("rolling back a relay proxy config that was never returned", "id", id, "name", entry.Name)
+	return DeleteRelayToken(ctx, client, id)
+}
+
+// createdBy reports whether a credential created at creationDate, in
+// milliseconds, may come from the create call of the entry.
+func (entry *walEntry) createdBy(creationDate int64) bool {
+	created := time.Unix(0, creationDate*int64(time.Millisecond))
+	start := time.Unix(0, entry.CreatedAt*int64(time.Millisecond))
+	return !created.Before(start.Add(-walClockSkew)) && created.Before(start.Add(walCreateWindow))
+}
+
+// rollbackMatch picks the credential to roll back among those found by name,
+// leaving out the claimed ones. Several matches cannot be told apart from
+// credentials being issued concurrently, so none of them is deleted.
+func (b *backend) rollbackMatch(kind string, entry *walEntry, matches []string) string {
+	switch len(matches) {
+	case 0:
+		return ""
+	case 1:
+		return matches[0]
+	default:
+		b.Logger().Warn(fmt.Sprintf("several %ss match a WAL entry, none was rolled back", kind), "name", entry.Name, "ids", matches)
+		return ""
+	}
+}
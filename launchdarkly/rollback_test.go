@@ -0,0 +1,161 @@
+package launchdarkly
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	ldapi "github.com/launchdarkly/api-client-go"
+)
+
+func TestWALRollback(t *testing.T) {
+	defer func(base time.Duration) { retryBaseDelay = base }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	createStatus := http.StatusCreated
+	var deleted []string
+	var createdName string
+	server := newFakeLD(t, map[string]http.HandlerFunc{
+		"/api/v2/tokens": func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				var body ldapi.TokenBody
+				decodeJSON(t, r, &body)
+				createdName = body.Name
+				writeJSON(w, createStatus, ldapi.Token{Id: "issued", Token: "api-issued"})
+				return
+			}
+			now := time.Now().UnixNano() / int64(time.Millisecond)
+			writeJSON(w, http.StatusOK, ldapi.Tokens{Items: []ldapi.Token{
+				{Id: "lost", Name: createdName, CreationDate: now},
+				{Id: "old", Name: createdName, CreationDate: now - int64(time.Hour/time.Millisecond)},
+				{Id: "other", Name: "other", CreationDate: now},
+			}})
+		},
+		"/api/v2/tokens/": func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodDelete {
+				writeJSON(w, http.StatusMethodNotAllowed, nil)
+				return
+			}
+			deleted = append(deleted, r.URL.Path[len("/api/v2/tokens/"):])
+			if r.URL.Path == "/api/v2/tokens/gone" {
+				writeJSON(w, http.StatusNotFound, nil)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		},
+	})
+
+	e, err := newTestAccEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wals := func() []string {
+		t.Helper()
+		keys, err := framework.ListWAL(e.Context, e.Storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keys
+	}
+	rollback := func() {
+		t.Helper()
+		e.mustRequest(t, logical.RollbackOperation, "", map[string]interface{}{"immediate": true})
+	}
+
+	e.mustRequest(t, logical.UpdateOperation, "config", map[string]interface{}{
+		"access_token": "api-1234",
+		"base_uri":     server.URL,
+		"skip_verify":  true,
+	})
+	e.mustRequest(t, logical.UpdateOperation, "role/"+roleTestName, map[string]interface{}{
+		"custom_role_ids": []string{"reader"},
+	})
+	creds := func() error {
+		_, err := e.request(logical.ReadOperation, "creds/"+roleTestName, nil)
+		return err
+	}
+
+	t.Run("issued", func(t *testing.T) {
+		if err := creds(); err != nil {
+			t.Fatal(err)
+		}
+		if keys := wals(); len(keys) != 0 {
+			t.Fatalf("expected the WAL entry to be removed, got %v", keys)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		createStatus = http.StatusBadRequest
+		if err := creds(); err == nil {
+			t.Fatal("expected the create call to fail")
+		}
+		if keys := wals(); len(keys) != 0 {
+			t.Fatalf("expected no WAL entry for a rejected create call, got %v", keys)
+		}
+	})
+
+	t.Run("unknown outcome", func(t *testing.T) {
+		createStatus = http.StatusBadGateway
+		deleted = nil
+		if err := creds(); err == nil {
+			t.Fatal("expected the create call to fail")
+		}
+		if keys := wals(); len(keys) != 1 {
+			t.Fatalf("expected the WAL entry to be kept, got %v", keys)
+		}
+
+		rollback()
+		if len(deleted) != 1 || deleted[0] != "lost" {
+			t.Fatalf("expected the lost token to be deleted, got %v", deleted)
+		}
+		if keys := wals(); len(keys) != 0 {
+			t.Fatalf("expected the WAL entry to be removed, got %v", keys)
+		}
+	})
+
+	t.Run("leased token with the same name", func(t *testing.T) {
+		deleted = nil
+		if err := e.Backend.(*backend).putIssued(e.Context, e.Storage, &issuedEntry{ID: "lost", CredentialType: "api", Connection: defaultConnection}); err != nil {
+			t.Fatal(err)
+		}
+		defer e.Storage.Delete(e.Context, issuedPrefix+"lost")
+		if _, err := framework.PutWAL(e.Context, e.Storage, walTokenKind, &walEntry{
+			Connection: defaultConnection,
+			Name:       createdName,
+			CreatedAt:  time.Now().UnixNano() / int64(time.Millisecond),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		rollback()
+		if len(deleted) != 0 {
+			t.Fatalf("expected the leased token to be kept, got %v", deleted)
+		}
+		if keys := wals(); len(keys) != 0 {
+			t.Fatalf("expected the WAL entry to be removed, got %v", keys)
+		}
+	})
+
+	t.Run("by id", func(t *testing.T) {
+		deleted = nil
+		for _, id := range []string{"issued", "gone"} {
+			if _, err := framework.PutWAL(e.Context, e.Storage, walTokenKind, &walEntry{
+				Connection: defaultConnection,
+				Name:       "vault-generated",
+				ID:         id,
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		rollback()
+		if len(deleted) != 2 {
+			t.Fatalf("expected both tokens to be deleted, got %v", deleted)
+		}
+		if keys := wals(); len(keys) != 0 {
+			t.Fatalf("expected the WAL entries to be removed, got %v", keys)
+		}
+	})
+}
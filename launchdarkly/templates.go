@@ -0,0 +1,107 @@
+package launchdarkly
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// nameTemplateData is what name_template and description_template can refer
+// to when a credential is issued.
+type nameTemplateData struct {
+	// Name is the name the credential gets without a template: the token_name
+	// of the role, the project or the relay policy.
+	Name        string
+	DisplayName string
+	EntityID    string
+	Role        string
+	Project     string
+	Environment string
+	Policy      string
+	// RequestID is the id of the Vault request. The lease id is not available:
+	// Vault only assigns it once the credential has been created.
+	RequestID string
+	Timestamp string
+	Random    string
+}
+
+func newNameTemplateData(req *logical.Request, name string) *nameTemplateData {
+	return &nameTemplateData{
+		Name:        name,
+		DisplayName: req.DisplayName,
+		EntityID:    req.EntityID,
+		RequestID:   req.ID,
+		Timestamp:   strconv.FormatInt(time.Now().Unix(), 10),
+		Random:      randomSuffix(),
+	}
+}
+
+// randomSuffix returns 8 random hex characters.
+func randomSuffix() string {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		// Fall back to the clock, which still tells concurrent requests apart.
+		return strconv.FormatInt(time.Now().UnixNano()&0xffffffff, 16)
+	}
+	return hex.EncodeToString(buf)
+}
+
+func renderTemplate(text string, data *nameTemplateData) (string, error) {
+	tmpl, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// validateTemplate checks that a template parses and renders.
+func validateTemplate(field, text string) error {
+	if text == "" {
+		return nil
+	}
+	if _, err := renderTemplate(text, &nameTemplateData{}); err != nil {
+		return fmt.Errorf("invalid %s: %v", field, err)
+	}
+	return nil
+}
+
+// credentialNames renders the name and description of a new credential with
+// the templates of the role, falling back to those of the connection. The
+// name is made unique with the random suffix if it does not hold it already,
+// so that a credential can be told apart from the others issued under the same
+// name. Without a name template, the usual name is used.
+func credentialNames(config *launchdarklyConfig, role *launchdarklyRoleEntry, data *nameTemplateData) (name, description string, err error) {
+	nameTemplate, descriptionTemplate := config.NameTemplate, config.DescriptionTemplate
+	if role != nil && role.NameTemplate != "" {
+		nameTemplate = role.NameTemplate
+	}
+	if role != nil && role.DescriptionTemplate != "" {
+		descriptionTemplate = role.DescriptionTemplate
+	}
+
+	name = data.Name
+	if nameTemplate != "" {
+		if name, err = renderTemplate(nameTemplate, data); err != nil {
+			return "", "", fmt.Errorf("could not render name_template: %v", err)
+		}
+	}
+	if !strings.Contains(name, data.Random) {
+		name += "-" + data.Random
+	}
+	if descriptionTemplate != "" {
+		if description, err = renderTemplate(descriptionTemplate, data); err != nil {
+			return "", "", fmt.Errorf("could not render description_template: %v", err)
+		}
+	}
+	return name, description, nil
+}
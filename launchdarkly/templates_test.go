@@ -0,0 +1,101 @@
+package launchdarkly
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	ldapi "github.com/launchdarkly/api-client-go"
+)
+
+func TestNameTemplates(t *testing.T) {
+	var names []string
+	descriptions := make(map[string]string)
+	server := newFakeLD(t, map[string]http.HandlerFunc{
+		"/api/v2/tokens": func(w http.ResponseWriter, r *http.Request) {
+			var body ldapi.TokenBody
+			decodeJSON(t, r, &body)
+			names = append(names, body.Name)
+			writeJSON(w, http.StatusCreated, ldapi.Token{Id: body.Name, Name: body.Name, Token: "api-issued"})
+		},
+		"/api/v2/tokens/": func(w http.ResponseWriter, r *http.Request) {
+			var patch []ldapi.PatchOperation
+			decodeJSON(t, r, &patch)
+			if len(patch) != 1 || patch[0].Path != "/description" {
+				t.Errorf("unexpected patch: %#v", patch)
+			}
+			descriptions[strings.TrimPrefix(r.URL.Path, "/api/v2/tokens/")] = (*patch[0].Value).(string)
+			writeJSON(w, http.StatusOK, ldapi.Token{})
+		},
+	})
+
+	e, err := newTestAccEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.mustRefuse(t, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Data: map[string]interface{}{
+			"access_token":  "api-1234",
+			"name_template": "{{.Unknown}}",
+		},
+	})
+	e.mustRequest(t, logical.UpdateOperation, "config", map[string]interface{}{
+		"access_token":         "api-1234",
+		"base_uri":             server.URL,
+		"skip_verify":          true,
+		"owner_prefix":         "vault-",
+		"name_template":        "{{.DisplayName}}-{{.Role}}",
+		"description_template": "Issued to {{.EntityID}} by request {{.RequestID}}",
+	})
+	e.mustRequest(t, logical.UpdateOperation, "role/templated", map[string]interface{}{
+		"custom_role_ids": []string{"reader"},
+		"name_template":   "{{.Name}}-{{.Role}}-{{.Random}}",
+	})
+	e.mustRequest(t, logical.UpdateOperation, "role/plain", map[string]interface{}{
+		"custom_role_ids": []string{"reader"},
+	})
+
+	for _, role := range []string{"templated", "plain", "plain"} {
+		e.mustHandle(t, &logical.Request{
+			ID:          "request-1",
+			Operation:   logical.ReadOperation,
+			Path:        "creds/" + role,
+			EntityID:    "entity-1",
+			DisplayName: "approle-ci",
+		})
+	}
+
+	if len(names) != 3 {
+		t.Fatalf("expected 3 tokens, got %v", names)
+	}
+	if !strings.HasPrefix(names[0], "vault-vault-generated-templated-") || len(names[0]) != len("vault-vault-generated-templated-")+8 {
+		t.Fatalf("expected the role template, got %q", names[0])
+	}
+	for _, name := range names[1:] {
+		if !strings.HasPrefix(name, "vault-approle-ci-plain-") {
+			t.Fatalf("expected the connection template with a random suffix, got %q", name)
+		}
+	}
+	if names[1] == names[2] {
+		t.Fatalf("expected unique names, got %q twice", names[1])
+	}
+	for _, name := range names {
+		if descriptions[name] != "Issued to entity-1 by request request-1" {
+			t.Fatalf("unexpected description of %s: %q", name, descriptions[name])
+		}
+	}
+
+	resp := e.mustRequest(t, logical.ReadOperation, "role/templated", nil)
+	if resp.Data["name_template"] != "{{.Name}}-{{.Role}}-{{.Random}}" {
+		t.Fatalf("unexpected name_template: %#v", resp.Data["name_template"])
+	}
+
+	untemplated := &nameTemplateData{Name: "relay", Random: "0123abcd"}
+	if name, _, err := credentialNames(&launchdarklyConfig{}, nil, untemplated); err != nil || name != "relay-0123abcd" {
+		t.Fatalf("expected the random suffix without a template, got %q, %v", name, err)
+	}
+}
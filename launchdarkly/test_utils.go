@@ -2,11 +2,16 @@ package launchdarkly
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/hashicorp/vault/sdk/logical"
+	ldapi "github.com/launchdarkly/api-client-go"
 )
 
 const (
@@ -59,3 +64,67 @@ func (e *testEnv) addConfig(t *testing.T) {
 		t.Fatal("expected nil response")
 	}
 }
+
+// handle sends req to the backend, on the storage of the environment.
+func (e *testEnv) handle(req *logical.Request) (*logical.Response, error) {
+	req.Storage = e.Storage
+	return e.Backend.HandleRequest(e.Context, req)
+}
+
+// mustHandle sends req to the backend and fails the test if it is refused.
+func (e *testEnv) mustHandle(t *testing.T, req *logical.Request) *logical.Response {
+	t.Helper()
+	resp, err := e.handle(req)
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: %s: resp: %#v\nerr:%v", req.Path, resp, err)
+	}
+	return resp
+}
+
+// mustRefuse sends req to the backend and fails the test unless it is refused.
+func (e *testEnv) mustRefuse(t *testing.T, req *logical.Request) {
+	t.Helper()
+	if resp, err := e.handle(req); err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected %s to be refused, got %#v, %v", req.Path, resp, err)
+	}
+}
+
+func (e *testEnv) request(operation logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+	return e.handle(&logical.Request{Operation: operation, Path: path, Data: data})
+}
+
+func (e *testEnv) mustRequest(t *testing.T, operation logical.Operation, path string, data map[string]interface{}) *logical.Response {
+	t.Helper()
+	return e.mustHandle(t, &logical.Request{Operation: operation, Path: path, Data: data})
+}
+
+// newFakeLD starts a stand-in for the LaunchDarkly API that serves handlers,
+// keyed by request path. Unless handlers cover them, every custom role exists.
+func newFakeLD(t *testing.T, handlers map[string]http.HandlerFunc) *httptest.Server {
+	mux := http.NewServeMux()
+	for pattern, handler := range handlers {
+		mux.HandleFunc(pattern, handler)
+	}
+	if _, ok := handlers["/api/v2/roles/"]; !ok {
+		mux.HandleFunc("/api/v2/roles/", func(w http.ResponseWriter, r *http.Request) {
+			key := strings.TrimPrefix(r.URL.Path, "/api/v2/roles/")
+			writeJSON(w, http.StatusOK, ldapi.CustomRole{Key: key, Name: key})
+		})
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func decodeJSON(t *testing.T, r *http.Request, v interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}